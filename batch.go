@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// batchRef is a single image@digest reference read in batch mode
+type batchRef struct {
+	Ref    string // reference as given in the input
+	Image  string
+	Digest string
+}
+
+// batchGroup collects the references that share a repository, so that its
+// tags are listed and checked only once
+type batchGroup struct {
+	repo    registry.Repository
+	image   string              // first image name seen, used in messages
+	digests []string            // distinct target digests, in input order
+	refs    map[string][]string // digest -> references asking for it
+}
+
+// parseBatchRefs reads image@digest references from r, one per line. A tag
+// before the digest, as in nginx:1.25@sha256:..., is dropped, since the
+// digest decides the match. Blank lines and lines starting with # are
+// ignored.
+func parseBatchRefs(r io.Reader) ([]batchRef, error) {
+	var refs []batchRef
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.LastIndex(line, "@")
+		if idx <= 0 || idx == len(line)-1 {
+			return nil, fmt.Errorf("line %d: expected image@digest, got %q", lineNo, line)
+		}
+
+		refs = append(refs, batchRef{
+			Ref:    line,
+			Image:  stripTag(strings.TrimPrefix(line[:idx], "docker://")),
+			Digest: line[idx+1:],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// groupBatchRefs groups references by registry and repository, preserving
+// the order in which repositories first appear
+func groupBatchRefs(refs []batchRef) []*batchGroup {
+	var groups []*batchGroup
+	byRepo := make(map[registry.Repository]*batchGroup)
+
+	for _, ref := range refs {
+		repo := registry.ParseRepository(ref.Image)
+
+		group, ok := byRepo[repo]
+		if !ok {
+			group = &batchGroup{
+				repo:  repo,
+				image: ref.Image,
+				refs:  make(map[string][]string),
+			}
+			byRepo[repo] = group
+			groups = append(groups, group)
+		}
+
+		if _, seen := group.refs[ref.Digest]; !seen {
+			group.digests = append(group.digests, ref.Digest)
+		}
+		group.refs[ref.Digest] = append(group.refs[ref.Digest], ref.Ref)
+	}
+
+	return groups
+}
+
+// checkBatch scans every group concurrently using one shared client, so the
+// client's worker budget bounds the total number of in-flight requests.
+// Each match is written to out as "<reference> <tag>", and onMatch, if not
+// nil, is called once for each target digest a tag matches. It returns the
+// matching tags for each reference, and a scan summary across all groups in
+// which failed tags are named "<image>:<tag>" (or just "<image>" if its tags
+// could not be listed).
+func checkBatch(ctx context.Context, client *registry.Client, groups []*batchGroup, out io.Writer, onMatch func(image, target string, result registry.TagInfo), quiet bool) (map[string][]string, scanResult) {
+	var (
+		mu      sync.Mutex
+		matches = make(map[string][]string)
+		scan    scanResult
+		wg      sync.WaitGroup
+	)
+
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group *batchGroup) {
+			defer wg.Done()
+
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Checking tags for %s (%d digest(s))...\n", group.image, len(group.digests))
+			}
+
+			// Tag listing draws from the same budget as manifest requests,
+			// and tags are checked as each page arrives
+			var listing registry.Listing
+			resultsChan := make(chan registry.TagInfo, client.Concurrency(group.repo)*2)
+			go client.FetchDigestsSeq(ctx, group.repo, listing.Tags(client.Tags(ctx, group.repo)), resultsChan)
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				scan.total += listing.Count
+				if listing.Err != nil {
+					scan.failed = append(scan.failed, registry.TagInfo{Tag: group.image, Err: listing.Err})
+				}
+			}()
+
+			matcher := newDigestMatcher(group.digests)
+			for result := range resultsChan {
+				mu.Lock()
+				scan.checked++
+				if result.Err != nil {
+					result.Tag = group.image + ":" + result.Tag
+					scan.failed = append(scan.failed, result)
+					mu.Unlock()
+					continue
+				}
+				if result.Retried {
+					scan.recovered = append(scan.recovered, group.image+":"+result.Tag)
+				}
+
+				for _, target := range matcher.match(result.Digest, result.SHA512) {
+					for _, ref := range group.refs[target] {
+						matches[ref] = append(matches[ref], result.Tag)
+						fmt.Fprintf(out, "%s %s\n", ref, result.Tag)
+					}
+					if onMatch != nil {
+						onMatch(group.image, target, result)
+					}
+				}
+				mu.Unlock()
+			}
+
+			for _, warning := range ambiguityWarnings(matcher) {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", group.image, warning)
+			}
+		}(group)
+	}
+
+	wg.Wait()
+	return matches, scan
+}
+
+// openInput opens path for reading, treating "-" as stdin
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// runBatchMode reads references from path ("-" for stdin) using parse and
+// reports the matching tags for each of them
+func runBatchMode(path string, parse func(io.Reader) ([]batchRef, error), opts clientOptions, minPrefix int, quiet bool) int {
+	in, err := openInput(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+	refs, err := parse(in)
+	_ = in.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+	if len(refs) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no references given")
+		return exitFatal
+	}
+	for i := range refs {
+		digest, err := parseDigest(refs[i].Digest, minPrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", refs[i].Ref, err)
+			return exitFatal
+		}
+		refs[i].Digest = digest
+		if needsSHA512([]string{digest}) {
+			opts.sha512 = true
+		}
+	}
+
+	ctx, cancel := opts.scanContext()
+	defer cancel()
+
+	// Setup signal handling for Ctrl+C
+	setupSignalHandler(cancel)
+
+	// Matches found before a timeout are still delivered; only Ctrl+C stops
+	// delivery
+	notifyCtx, cancelNotify := context.WithCancel(context.Background())
+	defer cancelNotify()
+	setupSignalHandler(cancelNotify)
+	events := newDispatcher(notifyCtx, opts.notifiers)
+
+	groups := groupBatchRefs(refs)
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Checking %d reference(s) across %d repositories...\n", len(refs), len(groups))
+	}
+
+	client := opts.newClient()
+	matches, scan := checkBatch(ctx, client, groups, os.Stdout, func(image, target string, match registry.TagInfo) {
+		events.send(matchEvent{Time: time.Now(), Image: image, Target: target, Tag: match.Tag, Digest: match.Digest, Change: changeMatched})
+	}, quiet)
+	events.close()
+
+	// Report references without matches so every input line gets a result
+	unmatched := 0
+	for _, ref := range refs {
+		if len(matches[ref.Ref]) == 0 {
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "No tags found for %s\n", ref.Ref)
+			}
+			unmatched++
+		}
+	}
+
+	if !quiet {
+		writeRecoveredSummary(os.Stderr, scan)
+	}
+	writeTimeout(ctx, os.Stderr, opts)
+	writeFailureSummary(os.Stderr, scan)
+	if unmatched == 0 {
+		return exitMatch
+	}
+	// Some references are unmatched: decide whether that is definite
+	scan.matches = 0
+	return scan.exitCode()
+}
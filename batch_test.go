@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oci-tag-finder/internal/registrytest"
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test parseBatchRefs function
+func TestParseBatchRefs(t *testing.T) {
+	input := `# workloads in prod
+ghcr.io/org/app@sha256:aaaa
+
+docker://docker.io/library/nginx@bbbb
+localhost:5000/team/api@sha256:cccc
+nginx:1.25@sha256:dddd
+localhost:5000/team/api:v2@sha256:eeee
+`
+	refs, err := parseBatchRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseBatchRefs() error = %v", err)
+	}
+
+	want := []batchRef{
+		{Ref: "ghcr.io/org/app@sha256:aaaa", Image: "ghcr.io/org/app", Digest: "sha256:aaaa"},
+		{Ref: "docker://docker.io/library/nginx@bbbb", Image: "docker.io/library/nginx", Digest: "bbbb"},
+		{Ref: "localhost:5000/team/api@sha256:cccc", Image: "localhost:5000/team/api", Digest: "sha256:cccc"},
+		{Ref: "nginx:1.25@sha256:dddd", Image: "nginx", Digest: "sha256:dddd"},
+		{Ref: "localhost:5000/team/api:v2@sha256:eeee", Image: "localhost:5000/team/api", Digest: "sha256:eeee"},
+	}
+	if !slices.Equal(refs, want) {
+		t.Errorf("parseBatchRefs() = %+v, want %+v", refs, want)
+	}
+
+	for _, bad := range []string{"nginx", "nginx@", "@sha256:aaaa"} {
+		if _, err := parseBatchRefs(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseBatchRefs(%q) expected error", bad)
+		}
+	}
+}
+
+// Test groupBatchRefs function
+func TestGroupBatchRefs(t *testing.T) {
+	refs := []batchRef{
+		{Ref: "nginx@sha256:aaaa", Image: "nginx", Digest: "sha256:aaaa"},
+		{Ref: "ghcr.io/org/app@sha256:bbbb", Image: "ghcr.io/org/app", Digest: "sha256:bbbb"},
+		{Ref: "docker.io/library/nginx@sha256:cccc", Image: "docker.io/library/nginx", Digest: "sha256:cccc"},
+		{Ref: "docker.io/nginx@sha256:aaaa", Image: "docker.io/nginx", Digest: "sha256:aaaa"},
+	}
+
+	groups := groupBatchRefs(refs)
+	if len(groups) != 2 {
+		t.Fatalf("groupBatchRefs() returned %d groups, want 2", len(groups))
+	}
+
+	nginx := groups[0]
+	if nginx.repo.Name != "library/nginx" {
+		t.Errorf("first group repository = %s, want library/nginx", nginx.repo.Name)
+	}
+	if !slices.Equal(nginx.digests, []string{"sha256:aaaa", "sha256:cccc"}) {
+		t.Errorf("nginx digests = %v", nginx.digests)
+	}
+	if got := nginx.refs["sha256:aaaa"]; !slices.Equal(got, []string{"nginx@sha256:aaaa", "docker.io/nginx@sha256:aaaa"}) {
+		t.Errorf("nginx refs for sha256:aaaa = %v", got)
+	}
+	if groups[1].repo.Name != "org/app" {
+		t.Errorf("second group repository = %s, want org/app", groups[1].repo.Name)
+	}
+}
+
+// Test checkBatch lists each repository once, keeps tokens per repository
+// and stays within the shared worker budget
+func TestCheckBatch(t *testing.T) {
+	repos := map[string]map[string]string{
+		"team/api": {"v1": "sha256:aaaa", "v2": "sha256:bbbb", "latest": "sha256:bbbb"},
+		"team/web": {"v1": "sha256:cccc", "latest": "sha256:dddd"},
+	}
+
+	var (
+		mu          sync.Mutex
+		listCalls   = make(map[string]int)
+		inFlight    int
+		maxInFlight int
+	)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"token": "token-for-" + r.URL.Query().Get("scope"),
+			})
+			return
+		}
+
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+
+		// Paths look like /v2/team/api/tags/list or /v2/team/api/manifests/v1
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		var repo string
+		for name := range repos {
+			if strings.HasPrefix(path, name+"/") {
+				repo = name
+			}
+		}
+
+		// Each repository only accepts its own token
+		scope := "repository:" + repo + ":pull"
+		if r.Header.Get("Authorization") != "Bearer token-for-"+scope {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="`+scope+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		rest := strings.TrimPrefix(path, repo+"/")
+		if rest == "tags/list" {
+			mu.Lock()
+			listCalls[repo]++
+			mu.Unlock()
+			var tags []string
+			for tag := range repos[repo] {
+				tags = append(tags, tag)
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+			return
+		}
+
+		tag := strings.TrimPrefix(rest, "manifests/")
+		w.Header().Set("Docker-Content-Digest", repos[repo][tag])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	refs := []batchRef{
+		{Ref: host + "/team/api@sha256:bbbb", Image: host + "/team/api", Digest: "sha256:bbbb"},
+		{Ref: host + "/team/web@sha256:cccc", Image: host + "/team/web", Digest: "sha256:cccc"},
+		{Ref: host + "/team/api@sha256:aaaa", Image: host + "/team/api", Digest: "sha256:aaaa"},
+		{Ref: host + "/team/web@sha256:eeee", Image: host + "/team/web", Digest: "sha256:eeee"},
+	}
+	groups := groupBatchRefs(refs)
+	for _, g := range groups {
+		// registry.ParseRepository assumes https; the test server speaks plain http
+		g.repo.Registry = server.URL
+	}
+
+	client := registry.NewClient(registry.WithConcurrency(2))
+	var out bytes.Buffer
+	matches, scan := checkBatch(context.Background(), client, groups, &out, nil, true)
+
+	want := map[string][]string{
+		refs[0].Ref: {"latest", "v2"},
+		refs[1].Ref: {"v1"},
+		refs[2].Ref: {"v1"},
+	}
+	for ref, wantTags := range want {
+		got := slices.Sorted(slices.Values(matches[ref]))
+		if !slices.Equal(got, wantTags) {
+			t.Errorf("matches[%s] = %v, want %v", ref, got, wantTags)
+		}
+	}
+	if len(matches[refs[3].Ref]) != 0 {
+		t.Errorf("expected no matches for %s, got %v", refs[3].Ref, matches[refs[3].Ref])
+	}
+
+	if len(scan.failed) != 0 || scan.checked != scan.total || scan.total != 5 {
+		t.Errorf("unexpected scan summary: %+v", scan)
+	}
+	if listCalls["team/api"] != 1 || listCalls["team/web"] != 1 {
+		t.Errorf("expected each repository to be listed once, got %v", listCalls)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 concurrent requests, got %d", maxInFlight)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 4 {
+		t.Errorf("expected 4 output lines, got %d:\n%s", lines, out.String())
+	}
+}
+
+// Test runBatchMode sends an event to -webhook/-exec notifiers for every match
+func TestRunBatchMode_Notifications(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	v1 := fake.PushImage("app", "v1")
+	fake.Tag("app", "latest", v1)
+	fake.PushImage("app", "v2")
+
+	input := filepath.Join(t.TempDir(), "refs.txt")
+	if err := os.WriteFile(input, []byte(fake.Ref("app")+"@"+v1+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var events bytes.Buffer
+	opts := fakeRegistryOptions(fake, "", "")
+	opts.notifiers = []notifier{&writerNotifier{w: &events, ndjson: true}}
+	var code int
+	captureStdout(t, func() {
+		code = runBatchMode(input, parseBatchRefs, opts, defaultMinPrefix, true)
+	})
+
+	var tags []string
+	decoder := json.NewDecoder(&events)
+	for decoder.More() {
+		var e matchEvent
+		if err := decoder.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Change != changeMatched || e.Image != fake.Ref("app") || e.Target != v1 || e.Digest != v1 {
+			t.Errorf("event = %+v", e)
+		}
+		tags = append(tags, e.Tag)
+	}
+	slices.Sort(tags)
+	if code != exitMatch || !slices.Equal(tags, []string{"latest", "v1"}) {
+		t.Errorf("runBatchMode() = %d, events for %v, want latest, v1", code, tags)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// fileConfig is the layout of the config file. Defaults are keyed by flag
+// name and apply to every registry; registries are keyed by registry host as
+// written in image references, e.g. docker.io or registry.internal:5000.
+//
+// Settings are resolved in this order, highest precedence first: command
+// line flags, TAG_FINDER_* environment variables, per-registry config,
+// config defaults, built-in defaults. A setting given on the command line or
+// in the environment replaces the same setting in the config file
+// everywhere, including in per-registry sections.
+type fileConfig struct {
+	Defaults   map[string]any            `yaml:"defaults"`
+	Registries map[string]registryConfig `yaml:"registries"`
+}
+
+// registryConfig holds the settings that can differ per registry
+type registryConfig struct {
+	Workers     int      `yaml:"workers"`
+	Rate        string   `yaml:"rate"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	PasswordEnv string   `yaml:"password-env"` // read the password from this environment variable
+	CAFile      string   `yaml:"ca-file"`
+	Mirrors     []string `yaml:"mirrors"`
+}
+
+// configExcludedFlags cannot be set from the config file's defaults
+var configExcludedFlags = []string{"config", "version"}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/oci-tag-finder/config.yaml,
+// falling back to ~/.config when XDG_CONFIG_HOME is unset
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "oci-tag-finder", "config.yaml")
+}
+
+// loadConfig reads the config file at path. A missing file is only an
+// error when the path was given explicitly.
+func loadConfig(path string, explicit bool) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// applyDefaults sets every flag named in the config defaults that was not
+// already set on the command line or in the environment. Values are parsed by the flags
+// themselves, so they accept exactly what the command line accepts; a list
+// sets a repeatable flag once per element.
+func (c *fileConfig) applyDefaults(fs *flag.FlagSet, set map[string]bool) error {
+	for _, name := range slices.Sorted(maps.Keys(c.Defaults)) {
+		if fs.Lookup(name) == nil || slices.Contains(configExcludedFlags, name) {
+			return fmt.Errorf("config defaults: unknown setting %q", name)
+		}
+		if set[name] {
+			continue
+		}
+
+		values, ok := c.Defaults[name].([]any)
+		if !ok {
+			values = []any{c.Defaults[name]}
+		}
+		for _, v := range values {
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("config defaults: %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// registryOptions are the resolved per-registry settings a client uses
+type registryOptions struct {
+	workers  int
+	username string
+	password string
+	rootCAs  *x509.CertPool
+	mirrors  []string // tried in order before the registry itself, as given to registry.WithMirrors
+}
+
+// flagsSet returns the names of the flags set on the command line
+func flagsSet(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// resolveRegistries turns the per-registry config into client settings
+// keyed by registry host, adding per-host rates to rates. Settings given on
+// the command line or in the environment (set) win over the config file.
+func (c *fileConfig) resolveRegistries(set map[string]bool, rates *registry.RateLimits) (map[string]registryOptions, error) {
+	registries := make(map[string]registryOptions)
+	for _, name := range slices.Sorted(maps.Keys(c.Registries)) {
+		rc := c.Registries[name]
+		host := registry.CanonicalHost(name)
+
+		if rc.Workers < 0 {
+			return nil, fmt.Errorf("registry %s: workers must not be negative", name)
+		}
+		ro := registryOptions{username: rc.Username, password: rc.Password}
+		if !set["workers"] {
+			ro.workers = rc.Workers
+		}
+
+		if rc.Rate != "" && !set["rate"] {
+			limit, err := registry.ParseRate(rc.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("registry %s: %v", name, err)
+			}
+			if rates.Hosts == nil {
+				rates.Hosts = make(map[string]rate.Limit)
+			}
+			rates.Hosts[host] = limit
+		}
+
+		if rc.PasswordEnv != "" {
+			password, ok := os.LookupEnv(rc.PasswordEnv)
+			if !ok {
+				return nil, fmt.Errorf("registry %s: environment variable %s is not set", name, rc.PasswordEnv)
+			}
+			ro.password = password
+		}
+
+		if rc.CAFile != "" {
+			pool, err := loadCAFile(rc.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("registry %s: %v", name, err)
+			}
+			ro.rootCAs = pool
+		}
+
+		ro.mirrors = rc.Mirrors
+
+		registries[host] = ro
+	}
+	return registries, nil
+}
+
+// loadCAFile returns the system certificate pool plus the PEM certificates
+// in path
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
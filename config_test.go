@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// writeConfig writes a config file into a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test loadConfig function
+func TestLoadConfig(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if cfg, err := loadConfig(missing, false); err != nil || len(cfg.Defaults) != 0 {
+		t.Errorf("loadConfig() of a missing default file = %+v, %v", cfg, err)
+	}
+	if _, err := loadConfig(missing, true); err == nil {
+		t.Error("loadConfig() of a missing explicit file expected error")
+	}
+
+	if _, err := loadConfig(writeConfig(t, ""), true); err != nil {
+		t.Errorf("loadConfig() of an empty file error = %v", err)
+	}
+
+	path := writeConfig(t, `defaults:
+  workers: 20
+  rate: [10/s, quay.io=2/s]
+registries:
+  registry.internal:5000:
+    workers: 4
+    mirrors: [mirror.internal]
+`)
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if cfg.Defaults["workers"] != 20 || cfg.Registries["registry.internal:5000"].Workers != 4 {
+		t.Errorf("loadConfig() = %+v", cfg)
+	}
+
+	if _, err := loadConfig(writeConfig(t, "registries:\n  ghcr.io:\n    wrokers: 4\n"), true); err == nil {
+		t.Error("loadConfig() expected error for an unknown field")
+	}
+}
+
+// Test applyDefaults leaves flags set on the command line alone
+func TestApplyDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	workers := fs.Int("workers", 10, "")
+	quiet := fs.Bool("quiet", false, "")
+	var rates registry.RateLimits
+	fs.Var(rateFlag{&rates}, "rate", "")
+	fs.String("config", "", "")
+
+	if err := fs.Parse([]string{"-workers", "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &fileConfig{Defaults: map[string]any{
+		"workers": 20,
+		"quiet":   true,
+		"rate":    []any{"10/s", "quay.io=2/s"},
+	}}
+	if err := cfg.applyDefaults(fs, flagsSet(fs)); err != nil {
+		t.Fatalf("applyDefaults() error = %v", err)
+	}
+
+	if *workers != 3 {
+		t.Errorf("workers = %d, want 3 from the command line", *workers)
+	}
+	if !*quiet {
+		t.Error("quiet = false, want true from the config file")
+	}
+	if rates.Default != 10 || rates.Hosts["quay.io"] != 2 {
+		t.Errorf("rates = %+v", rates)
+	}
+
+	for _, bad := range []map[string]any{
+		{"wrokers": 1},
+		{"config": "other.yaml"},
+		{"workers": "many"},
+	} {
+		if err := (&fileConfig{Defaults: bad}).applyDefaults(fs, nil); err == nil {
+			t.Errorf("applyDefaults(%v) expected error", bad)
+		}
+	}
+}
+
+// Test resolveRegistries function
+func TestResolveRegistries(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "from-env")
+
+	cfg := &fileConfig{Registries: map[string]registryConfig{
+		"docker.io": {
+			Workers: 4,
+			Rate:    "5/s",
+			Mirrors: []string{"mirror.gcr.io", "http://localhost:5000/"},
+		},
+		"registry.internal": {
+			Username:    "robot",
+			Password:    "ignored",
+			PasswordEnv: "TEST_REGISTRY_PASSWORD",
+		},
+	}}
+
+	var rates registry.RateLimits
+	registries, err := cfg.resolveRegistries(nil, &rates)
+	if err != nil {
+		t.Fatalf("resolveRegistries() error = %v", err)
+	}
+
+	hub := registries["registry-1.docker.io"]
+	if hub.workers != 4 {
+		t.Errorf("docker.io workers = %d, want 4", hub.workers)
+	}
+	// Mirrors are normalised by registry.WithMirrors
+	if !slices.Equal(hub.mirrors, []string{"mirror.gcr.io", "http://localhost:5000/"}) {
+		t.Errorf("docker.io mirrors = %v", hub.mirrors)
+	}
+	if rates.Hosts["registry-1.docker.io"] != 5 {
+		t.Errorf("docker.io rate = %v, want 5", rates.Hosts["registry-1.docker.io"])
+	}
+	if internal := registries["registry.internal"]; internal.username != "robot" || internal.password != "from-env" {
+		t.Errorf("registry.internal credentials = %q/%q", internal.username, internal.password)
+	}
+
+	// Flags given on the command line replace per-registry settings
+	rates = registry.RateLimits{}
+	registries, err = cfg.resolveRegistries(map[string]bool{"workers": true, "rate": true}, &rates)
+	if err != nil {
+		t.Fatalf("resolveRegistries() error = %v", err)
+	}
+	if registries["registry-1.docker.io"].workers != 0 || len(rates.Hosts) != 0 {
+		t.Errorf("command line flags did not take precedence: %+v, %+v", registries, rates)
+	}
+
+	bad := &fileConfig{Registries: map[string]registryConfig{"ghcr.io": {PasswordEnv: "TEST_REGISTRY_UNSET"}}}
+	if _, err := bad.resolveRegistries(nil, &registry.RateLimits{}); err == nil {
+		t.Error("resolveRegistries() expected error for an unset password variable")
+	}
+	bad = &fileConfig{Registries: map[string]registryConfig{"ghcr.io": {Rate: "fast"}}}
+	if _, err := bad.resolveRegistries(nil, &registry.RateLimits{}); err == nil {
+		t.Error("resolveRegistries() expected error for an invalid rate")
+	}
+}
+
+// Test a client built from per-registry settings trusts the registry's CA
+// and sends its credentials when requesting a token
+func TestNewClient_RegistrySettings(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "private-token"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer private-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	cfg := &fileConfig{Registries: map[string]registryConfig{
+		host: {Workers: 2, Username: "robot", Password: "secret", CAFile: caFile},
+	}}
+	opts := clientOptions{workers: 10}
+	registries, err := cfg.resolveRegistries(nil, &opts.rates)
+	if err != nil {
+		t.Fatalf("resolveRegistries() error = %v", err)
+	}
+	opts.registries = registries
+	client := opts.newClient()
+
+	repo := registry.Repository{Registry: server.URL, Name: "team/app"}
+	digest, err := client.ManifestDigest(context.Background(), repo, "v1")
+	if err != nil || digest != "sha256:aaaa" {
+		t.Errorf("ManifestDigest() = %q, %v", digest, err)
+	}
+	if got := client.Concurrency(repo); got != 2 {
+		t.Errorf("expected a per-registry budget of 2, got %d", got)
+	}
+	if got := client.Concurrency(registry.ParseRepository("ghcr.io/team/app")); got != 10 {
+		t.Errorf("expected other registries to share the default budget of 10, got %d", got)
+	}
+
+	// Without the CA the same request fails certificate verification
+	if _, err := (clientOptions{workers: 1}).newClient().ManifestDigest(context.Background(), repo, "v1"); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+}
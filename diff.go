@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// Statuses of a tag that differs between two scans
+const (
+	diffMissingLeft  = "missing-left"  // only the right side has the tag
+	diffMissingRight = "missing-right" // only the left side has the tag
+	diffDigest       = "differs"       // both have it, pointing at different digests
+)
+
+// savedScan is the tag -> digest inventory of an image, as saved to a file
+// by diff -save-left or -save-right
+type savedScan struct {
+	Image    string            `json:"image"`
+	Time     time.Time         `json:"time"`
+	Complete bool              `json:"complete"`         // every tag was listed and checked
+	Tags     map[string]string `json:"tags"`             // tag -> digest
+	Failed   []string          `json:"failed,omitempty"` // tags that could not be checked
+}
+
+func newSavedScan(image string, inv inventory) savedScan {
+	scan := savedScan{Image: image, Time: time.Now().UTC(), Complete: inv.complete(), Tags: inv.digests}
+	for _, f := range inv.failed {
+		scan.Failed = append(scan.Failed, f.Tag)
+	}
+	slices.Sort(scan.Failed)
+	return scan
+}
+
+// loadScan reads a scan saved with save
+func loadScan(path string) (savedScan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return savedScan{}, err
+	}
+	var scan savedScan
+	if err := json.Unmarshal(data, &scan); err != nil {
+		return savedScan{}, fmt.Errorf("not a saved scan: %v", err)
+	}
+	if scan.Tags == nil {
+		return savedScan{}, errors.New("not a saved scan (no tags)")
+	}
+	return scan, nil
+}
+
+// save writes the scan to path as JSON
+func (s savedScan) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// diffEntry is a tag that differs between two scans
+type diffEntry struct {
+	Tag    string `json:"tag"`
+	Status string `json:"status"`
+	Left   string `json:"left,omitempty"`  // the digest on the left, if it has the tag
+	Right  string `json:"right,omitempty"` // the digest on the right, if it has the tag
+}
+
+// diffReport compares two scans
+type diffReport struct {
+	Left        string      `json:"left"`
+	Right       string      `json:"right"`
+	Differences []diffEntry `json:"differences"` // sorted by tag
+	Identical   int         `json:"identical"`   // tags with the same digest on both sides
+	Unchecked   []string    `json:"unchecked,omitempty"`
+}
+
+// diffScans compares the tags of two scans. Tags that either side failed to
+// check are left out of the comparison and listed as unchecked, so a failure
+// never shows up as a missing tag.
+func diffScans(left, right savedScan) diffReport {
+	report := diffReport{Left: left.Image, Right: right.Image, Differences: []diffEntry{}}
+
+	unchecked := make(map[string]bool)
+	for _, tag := range slices.Concat(left.Failed, right.Failed) {
+		unchecked[tag] = true
+	}
+
+	tags := slices.Sorted(maps.Keys(left.Tags))
+	for tag := range right.Tags {
+		if _, ok := left.Tags[tag]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+
+	for _, tag := range tags {
+		if unchecked[tag] {
+			continue
+		}
+		l, inLeft := left.Tags[tag]
+		r, inRight := right.Tags[tag]
+		switch {
+		case !inLeft:
+			report.Differences = append(report.Differences, diffEntry{Tag: tag, Status: diffMissingLeft, Right: r})
+		case !inRight:
+			report.Differences = append(report.Differences, diffEntry{Tag: tag, Status: diffMissingRight, Left: l})
+		case !strings.EqualFold(l, r):
+			report.Differences = append(report.Differences, diffEntry{Tag: tag, Status: diffDigest, Left: l, Right: r})
+		default:
+			report.Identical++
+		}
+	}
+	report.Unchecked = slices.Sorted(maps.Keys(unchecked))
+	return report
+}
+
+// exitCode follows diff(1): identical, different, or trouble
+func (r diffReport) exitCode() int {
+	switch {
+	case len(r.Differences) > 0:
+		return exitDiffers
+	case len(r.Unchecked) > 0:
+		return exitIncomplete
+	default:
+		return exitIdentical
+	}
+}
+
+// writeDiff writes the differences as a table, and a summary to summary
+func writeDiff(w, summary io.Writer, r diffReport) error {
+	if len(r.Differences) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TAG\tSTATUS\tLEFT\tRIGHT")
+		for _, d := range r.Differences {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Tag, d.Status, orDash(d.Left), orDash(d.Right))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, d := range r.Differences {
+		counts[d.Status]++
+	}
+	fmt.Fprintf(summary, "%d identical, %d differ, %d missing on the left (%s), %d missing on the right (%s)\n",
+		r.Identical, counts[diffDigest], counts[diffMissingLeft], r.Left, counts[diffMissingRight], r.Right)
+	if len(r.Unchecked) > 0 {
+		fmt.Fprintf(summary, "Warning: %d tags could not be compared: %s\n", len(r.Unchecked), strings.Join(r.Unchecked, ", "))
+	}
+	return nil
+}
+
+// orDash returns s, or "-" if it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// isSavedScan reports whether a diff argument names a saved scan rather than
+// an image: a file that exists, whatever its name, since repository names
+// may end in .json too
+func isSavedScan(arg string) bool {
+	info, err := os.Stat(arg)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// runDiff runs the diff subcommand: it compares the tags of two images, or
+// of scans saved earlier, and reports tags missing on either side and tags
+// whose digests differ
+func runDiff(args []string, opts clientOptions, _ int) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "write the report as JSON")
+	saveLeft := fs.String("save-left", "", "save the left scan to `file`, for diffing against later")
+	saveRight := fs.String("save-right", "", "save the right scan to `file`, for diffing against later")
+	if err := fs.Parse(args); err != nil {
+		return exitFatal
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: tag-finder [flags] diff [-json] [-save-left file] [-save-right file] <image|scan.json> <image|scan.json>")
+		return exitFatal
+	}
+
+	ctx, cancel := opts.scanContext()
+	defer cancel()
+	setupSignalHandler(cancel)
+
+	// Both sides are scanned at once, sharing one client and so its limits
+	var client *registry.Client
+	if !isSavedScan(fs.Arg(0)) || !isSavedScan(fs.Arg(1)) {
+		client = opts.newClient()
+	}
+	scans := make([]savedScan, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, arg := range fs.Args() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scans[i], errs[i] = loadOrScan(ctx, client, arg)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", fs.Arg(i), err)
+			if registry.IsAuthError(err) {
+				return exitAuth
+			}
+			return exitFatal
+		}
+	}
+
+	for i, path := range []string{*saveLeft, *saveRight} {
+		if path == "" {
+			continue
+		}
+		if err := scans[i].save(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: saving scan: %v\n", err)
+			return exitFatal
+		}
+	}
+
+	report := diffScans(scans[0], scans[1])
+	var err error
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeDiff(os.Stdout, os.Stderr, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+	return report.exitCode()
+}
+
+// loadOrScan loads a saved scan, or scans an image. A scan whose tag listing
+// failed is an error, since the tags it did not list would all show up as
+// missing.
+func loadOrScan(ctx context.Context, client *registry.Client, arg string) (savedScan, error) {
+	if isSavedScan(arg) {
+		return loadScan(arg)
+	}
+	image := strings.TrimPrefix(arg, "docker://")
+	inv := scanInventory(ctx, client, registry.ParseRepository(image))
+	if inv.err != nil {
+		if strings.HasSuffix(arg, ".json") {
+			// Most likely a saved scan that is not there
+			return savedScan{}, fmt.Errorf("no saved scan with that name, and scanning it as an image failed: %w", inv.err)
+		}
+		return savedScan{}, inv.err
+	}
+	return newSavedScan(image, inv), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"oci-tag-finder/internal/registrytest"
+)
+
+// Test diffScans reports missing and differing tags, and leaves out tags a
+// side could not check
+func TestDiffScans(t *testing.T) {
+	tests := []struct {
+		name      string
+		left      savedScan
+		right     savedScan
+		want      []diffEntry
+		identical int
+		unchecked []string
+		exitCode  int
+	}{
+		{
+			name:      "identical",
+			left:      savedScan{Tags: map[string]string{"v1": "sha256:aaaa", "v2": "sha256:bbbb"}},
+			right:     savedScan{Tags: map[string]string{"v1": "sha256:aaaa", "v2": "sha256:BBBB"}},
+			identical: 2,
+			exitCode:  exitIdentical,
+		},
+		{
+			name:  "missing and different",
+			left:  savedScan{Tags: map[string]string{"v1": "sha256:aaaa", "v2": "sha256:bbbb", "latest": "sha256:bbbb"}},
+			right: savedScan{Tags: map[string]string{"v1": "sha256:aaaa", "v3": "sha256:cccc", "latest": "sha256:cccc"}},
+			want: []diffEntry{
+				{Tag: "latest", Status: diffDigest, Left: "sha256:bbbb", Right: "sha256:cccc"},
+				{Tag: "v2", Status: diffMissingRight, Left: "sha256:bbbb"},
+				{Tag: "v3", Status: diffMissingLeft, Right: "sha256:cccc"},
+			},
+			identical: 1,
+			exitCode:  exitDiffers,
+		},
+		{
+			name:      "failed tags are not missing",
+			left:      savedScan{Tags: map[string]string{"v1": "sha256:aaaa", "v2": "sha256:bbbb"}},
+			right:     savedScan{Tags: map[string]string{"v1": "sha256:aaaa"}, Failed: []string{"v2"}},
+			identical: 1,
+			unchecked: []string{"v2"},
+			exitCode:  exitIncomplete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := diffScans(tt.left, tt.right)
+			if !slices.Equal(report.Differences, tt.want) {
+				t.Errorf("differences = %+v, want %+v", report.Differences, tt.want)
+			}
+			if report.Identical != tt.identical || !slices.Equal(report.Unchecked, tt.unchecked) {
+				t.Errorf("identical = %d, unchecked = %v, want %d and %v", report.Identical, report.Unchecked, tt.identical, tt.unchecked)
+			}
+			if code := report.exitCode(); code != tt.exitCode {
+				t.Errorf("exitCode() = %d, want %d", code, tt.exitCode)
+			}
+		})
+	}
+}
+
+// Test runDiff compares two repositories, and a saved scan against a
+// repository
+func TestRunDiff(t *testing.T) {
+	fake := registrytest.New(registrytest.WithPageSize(2))
+	defer fake.Close()
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		digest := fake.PushImage("hub/app", tag)
+		fake.Tag("mirror/app", tag, digest)
+		fake.Tag("mirror/app.json", tag, digest)
+	}
+	opts := fakeRegistryOptions(fake, "", "")
+	hub, mirror := fake.Ref("hub/app"), fake.Ref("mirror/app")
+	saved := filepath.Join(t.TempDir(), "hub.json")
+
+	var code int
+	out := captureStdout(t, func() {
+		code = runDiff([]string{"-save-left", saved, hub, mirror}, opts, defaultMinPrefix)
+	})
+	if code != exitIdentical || out != "" {
+		t.Errorf("identical repositories: runDiff() = %d, output %q", code, out)
+	}
+
+	// The hub moves on: a new tag, and v1 is rebuilt
+	fake.PushImage("hub/app", "v4")
+	fake.PushImage("hub/app", "v1")
+	out = captureStdout(t, func() {
+		code = runDiff([]string{"-json", hub, mirror}, opts, defaultMinPrefix)
+	})
+	var report diffReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	var got []string
+	for _, d := range report.Differences {
+		got = append(got, d.Tag+" "+d.Status)
+	}
+	if want := []string{"v1 differs", "v4 missing-right"}; code != exitDiffers || !slices.Equal(got, want) || report.Identical != 2 {
+		t.Errorf("runDiff() = %d, differences %v, identical %d, want %v and 2", code, got, report.Identical, want)
+	}
+
+	// The scan saved earlier still matches the mirror
+	out = captureStdout(t, func() {
+		code = runDiff([]string{saved, mirror}, opts, defaultMinPrefix)
+	})
+	if code != exitIdentical {
+		t.Errorf("saved scan: runDiff() = %d, output %q", code, out)
+	}
+
+	// But not the hub, shown as a table
+	out = captureStdout(t, func() {
+		code = runDiff([]string{saved, hub}, opts, defaultMinPrefix)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != exitDiffers || len(lines) != 3 || !slices.Equal(strings.Fields(lines[2])[:3], []string{"v4", "missing-left", "-"}) {
+		t.Errorf("saved scan against hub: runDiff() = %d, output:\n%s", code, out)
+	}
+
+	// An image is scanned even when its name ends in .json
+	out = captureStdout(t, func() {
+		code = runDiff([]string{saved, fake.Ref("mirror/app.json")}, opts, defaultMinPrefix)
+	})
+	if code != exitIdentical {
+		t.Errorf("repository named like a saved scan: runDiff() = %d, output %q", code, out)
+	}
+
+	// A side that cannot be listed is an error, not a repository full of
+	// missing tags
+	fake.Inject(registrytest.Fault{Path: "/mirror/app/tags/", Status: http.StatusNotFound})
+	if code := runDiff([]string{hub, mirror}, opts, defaultMinPrefix); code != exitFatal {
+		t.Errorf("failed listing: runDiff() = %d, want %d", code, exitFatal)
+	}
+	if code := runDiff([]string{fake.Ref("missing.json"), hub}, opts, defaultMinPrefix); code != exitFatal {
+		t.Errorf("missing saved scan: runDiff() = %d, want %d", code, exitFatal)
+	}
+	invalid := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(invalid, []byte("not a scan"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := runDiff([]string{invalid, hub}, opts, defaultMinPrefix); code != exitFatal {
+		t.Errorf("invalid saved scan: runDiff() = %d, want %d", code, exitFatal)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// defaultMinPrefix is the shortest digest prefix accepted by default. Twelve
+// hex characters is the short image ID length shown by docker and most UIs.
+const defaultMinPrefix = 12
+
+// checkMinPrefix validates the -min-prefix setting. Below 1, a bare
+// "sha256:" would be a valid prefix and match every tag.
+func checkMinPrefix(n int) error {
+	if n < 1 {
+		return fmt.Errorf("min-prefix must be at least 1, got %d", n)
+	}
+	return nil
+}
+
+// digestAlgorithms maps each supported digest algorithm to the number of hex
+// characters in its encoded form
+var digestAlgorithms = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+// parseDigest validates a target digest following the OCI digest grammar
+// (algorithm ":" encoded) and returns it in canonical lowercase form. Only
+// sha256 and sha512 are supported. A bare hex string is taken to be sha256,
+// or sha512 when it is longer than a sha256 digest. Anything shorter than a
+// full digest is treated as a prefix and must be at least minPrefix hex
+// characters long.
+func parseDigest(s string, minPrefix int) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(s))
+
+	algorithm, hex, found := strings.Cut(normalized, ":")
+	if !found {
+		hex = normalized
+		algorithm = "sha256"
+		if len(hex) > digestAlgorithms["sha256"] {
+			algorithm = "sha512"
+		}
+	}
+
+	if !validDigestAlgorithm(algorithm) {
+		return "", fmt.Errorf("invalid digest %q: malformed algorithm %q", s, algorithm)
+	}
+	hexLen, ok := digestAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("invalid digest %q: unsupported algorithm %q (supported: sha256, sha512)", s, algorithm)
+	}
+	if strings.Contains(hex, ":") {
+		return "", fmt.Errorf("invalid digest %q: more than one algorithm prefix", s)
+	}
+	for _, c := range hex {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", fmt.Errorf("invalid digest %q: %q is not a hex character", s, c)
+		}
+	}
+	switch {
+	case len(hex) > hexLen:
+		return "", fmt.Errorf("invalid digest %q: too long for %s (%d hex characters, want %d)", s, algorithm, len(hex), hexLen)
+	case len(hex) < hexLen && len(hex) < max(minPrefix, 1):
+		return "", fmt.Errorf("invalid digest %q: prefix must be at least %d hex characters", s, minPrefix)
+	}
+
+	return algorithm + ":" + hex, nil
+}
+
+// validDigestAlgorithm reports whether algorithm matches the OCI grammar:
+// lowercase alphanumeric components separated by one of "+._-"
+func validDigestAlgorithm(algorithm string) bool {
+	if algorithm == "" {
+		return false
+	}
+	separator := true // no leading separator
+	for _, c := range algorithm {
+		switch {
+		case (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9'):
+			separator = false
+		case strings.ContainsRune("+._-", c) && !separator:
+			separator = true
+		default:
+			return false
+		}
+	}
+	return !separator
+}
+
+// isDigestPrefix reports whether a parsed target digest is only a prefix
+func isDigestPrefix(target string) bool {
+	algorithm, hex, _ := strings.Cut(target, ":")
+	return len(hex) < digestAlgorithms[algorithm]
+}
+
+// digestMatcher matches manifest digests against a set of target digests,
+// some of which may be prefixes. It remembers which distinct digests each
+// target matched so ambiguous prefixes can be reported.
+type digestMatcher struct {
+	targets []string
+	matched map[string][]string // target -> distinct digests it matched
+}
+
+func newDigestMatcher(targets []string) *digestMatcher {
+	return &digestMatcher{
+		targets: targets,
+		matched: make(map[string][]string),
+	}
+}
+
+// match returns the targets that any of digests satisfies, e.g. a tag's
+// sha256 and sha512 digests; empty digests are skipped. Digests are compared
+// case-insensitively, since targets are stored in lowercase.
+func (dm *digestMatcher) match(digests ...string) []string {
+	var hits []string
+	for _, digest := range digests {
+		if digest == "" {
+			continue
+		}
+		digest = strings.ToLower(digest)
+		for _, target := range dm.targets {
+			if digest != target && !(isDigestPrefix(target) && strings.HasPrefix(digest, target)) {
+				continue
+			}
+			if !slices.Contains(hits, target) {
+				hits = append(hits, target)
+			}
+			if !slices.Contains(dm.matched[target], digest) {
+				dm.matched[target] = append(dm.matched[target], digest)
+			}
+		}
+	}
+	return hits
+}
+
+// needsSHA512 reports whether any target is a sha512 digest, which can only
+// be matched by hashing manifests (see registry.WithSHA512)
+func needsSHA512(targets []string) bool {
+	return slices.ContainsFunc(targets, func(t string) bool { return strings.HasPrefix(t, "sha512:") })
+}
+
+// ambiguous returns, for each prefix target that matched more than one
+// distinct digest, the digests it matched
+func (dm *digestMatcher) ambiguous() map[string][]string {
+	result := make(map[string][]string)
+	for target, digests := range dm.matched {
+		if len(digests) > 1 {
+			result[target] = digests
+		}
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	testDigestA = "sha256:569a4c3f0ef68ae8103e85d3e0a7409f3065895f005ab189f10f57c3cc387a8d"
+	testDigestB = "sha256:569a4c3f0ef6ffffffffffffffffffffffffffffffffffffffffffffffffffff"
+	testDigestC = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testSHA512  = "sha512:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" +
+		"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+// Test parseDigest function
+func TestParseDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "full digest", input: testDigestA, want: testDigestA},
+		{name: "bare hex", input: strings.TrimPrefix(testDigestA, "sha256:"), want: testDigestA},
+		{name: "short prefix", input: "569a4c3f0ef6", want: "sha256:569a4c3f0ef6"},
+		{name: "prefix with algorithm", input: "sha256:569a4c3f0ef68ae8", want: "sha256:569a4c3f0ef68ae8"},
+		{name: "prefix too short", input: "569a4c3f", wantErr: "at least 12"},
+		{name: "too long", input: testDigestA + "00", wantErr: "too long"},
+		{name: "non-hex", input: "sha256:569a4c3f0ef6zz", wantErr: "hex"},
+		{name: "uppercase is normalized", input: strings.ToUpper(testDigestA), want: testDigestA},
+		{name: "sha512 digest", input: testSHA512, want: testSHA512},
+		{name: "bare sha512 hex", input: strings.TrimPrefix(testSHA512, "sha512:"), want: testSHA512},
+		{name: "sha512 prefix", input: "sha512:0123456789abcdef", want: "sha512:0123456789abcdef"},
+		{name: "sha256 length under sha512", input: "sha512:" + strings.TrimPrefix(testDigestA, "sha256:"), want: "sha512:" + strings.TrimPrefix(testDigestA, "sha256:")},
+		{name: "sha512 too long", input: testSHA512 + "0", wantErr: "too long for sha512"},
+		{name: "double algorithm prefix", input: "sha256:" + testSHA512, wantErr: "more than one algorithm"},
+		{name: "unsupported algorithm", input: "md5:569a4c3f0ef68ae8103e85d3e0a7409f", wantErr: "unsupported algorithm"},
+		{name: "malformed algorithm", input: "sha 256:569a4c3f0ef68ae8", wantErr: "malformed algorithm"},
+		{name: "leading separator", input: "-sha256:569a4c3f0ef68ae8", wantErr: "malformed algorithm"},
+		{name: "empty algorithm", input: ":569a4c3f0ef68ae8", wantErr: "malformed algorithm"},
+		{name: "empty", input: "", wantErr: "at least 12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDigest(tt.input, defaultMinPrefix)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseDigest(%q) error = %v, want error containing %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDigest(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseDigest(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test a minimum prefix below 1 is rejected, and never lets an empty prefix
+// through to match every tag
+func TestMinPrefix(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if err := checkMinPrefix(n); err == nil {
+			t.Errorf("checkMinPrefix(%d) succeeded, want an error", n)
+		}
+		for _, input := range []string{"sha256:", ""} {
+			if got, err := parseDigest(input, n); err == nil {
+				t.Errorf("parseDigest(%q, %d) = %q, want an error", input, n, got)
+			}
+		}
+	}
+	for _, n := range []int{1, defaultMinPrefix} {
+		if err := checkMinPrefix(n); err != nil {
+			t.Errorf("checkMinPrefix(%d) = %v", n, err)
+		}
+	}
+	if got, err := parseDigest("sha256:5", 1); err != nil || got != "sha256:5" {
+		t.Errorf("parseDigest(sha256:5, 1) = %q, %v", got, err)
+	}
+}
+
+// Test digestMatcher with full digests and prefixes
+func TestDigestMatcher(t *testing.T) {
+	unique := "sha256:0123456789ab"
+	shared := "sha256:569a4c3f0ef6"
+	dm := newDigestMatcher([]string{testDigestA, unique, shared})
+
+	if got := dm.match(testDigestA); !slices.Equal(got, []string{testDigestA, shared}) {
+		t.Errorf("match(A) = %v, want [A shared-prefix]", got)
+	}
+	if got := dm.match(testDigestB); !slices.Equal(got, []string{shared}) {
+		t.Errorf("match(B) = %v, want [shared-prefix]", got)
+	}
+	if got := dm.match(testDigestC); !slices.Equal(got, []string{unique}) {
+		t.Errorf("match(C) = %v, want [unique-prefix]", got)
+	}
+	if got := dm.match("sha256:ffff"); len(got) != 0 {
+		t.Errorf("match(unrelated) = %v, want none", got)
+	}
+
+	// Manifest digests are compared case-insensitively
+	if got := newDigestMatcher([]string{testDigestA}).match(strings.ToUpper(testDigestA)); len(got) != 1 {
+		t.Errorf("match(uppercase A) = %v, want a match", got)
+	}
+
+	// sha512 targets never match sha256 digests and vice versa
+	if got := newDigestMatcher([]string{"sha512:569a4c3f0ef6"}).match(testDigestA); len(got) != 0 {
+		t.Errorf("sha512 prefix matched a sha256 digest: %v", got)
+	}
+	if got := newDigestMatcher([]string{testSHA512}).match(testSHA512); len(got) != 1 {
+		t.Errorf("match(sha512) = %v, want a match", got)
+	}
+
+	// A tag matches through either of its digests, each target once
+	both := newDigestMatcher([]string{testDigestA, testSHA512, "sha512:0123456789ab"})
+	if got := both.match(testDigestA, testSHA512); !slices.Equal(got, []string{testDigestA, testSHA512, "sha512:0123456789ab"}) {
+		t.Errorf("match(A, sha512) = %v, want all three targets", got)
+	}
+	if got := both.match(testDigestB, ""); len(got) != 0 {
+		t.Errorf("match(B, \"\") = %v, want none", got)
+	}
+
+	// A full digest never matches by prefix
+	if got := newDigestMatcher([]string{testDigestA}).match(testDigestA + "00"); len(got) != 0 {
+		t.Errorf("full digest matched a longer digest: %v", got)
+	}
+
+	ambiguous := dm.ambiguous()
+	if len(ambiguous) != 1 {
+		t.Fatalf("ambiguous() = %v, want only the shared prefix", ambiguous)
+	}
+	if got := ambiguous[shared]; !slices.Equal(got, []string{testDigestA, testDigestB}) {
+		t.Errorf("ambiguous()[shared] = %v", got)
+	}
+
+	warnings := ambiguityWarnings(dm)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], shared) {
+		t.Errorf("ambiguityWarnings() = %v", warnings)
+	}
+}
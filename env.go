@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// envPrefix is prepended to flag names to form their environment variables
+const envPrefix = "TAG_FINDER_"
+
+// envExcludedFlags are not read from the environment
+var envExcludedFlags = []string{"version"}
+
+// envVarName returns the environment variable for a flag, e.g.
+// TAG_FINDER_RETRY_PASSES for -retry-passes
+func envVarName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// documentEnvVars adds each flag's environment variable to its usage text
+func documentEnvVars(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(envExcludedFlags, f.Name) {
+			f.Usage += " [$" + envVarName(f.Name) + "]"
+		}
+	})
+}
+
+// applyEnv sets every flag not set on the command line from its environment
+// variable, if present, and marks it as set so the config file cannot
+// override it
+func applyEnv(fs *flag.FlagSet, set map[string]bool, lookup func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || slices.Contains(envExcludedFlags, f.Name) {
+			return
+		}
+		name := envVarName(f.Name)
+		value, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", name, setErr)
+			return
+		}
+		set[f.Name] = true
+	})
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test envVarName function
+func TestEnvVarName(t *testing.T) {
+	tests := map[string]string{
+		"workers":      "TAG_FINDER_WORKERS",
+		"retry-passes": "TAG_FINDER_RETRY_PASSES",
+		"min-prefix":   "TAG_FINDER_MIN_PREFIX",
+	}
+	for name, want := range tests {
+		if got := envVarName(name); got != want {
+			t.Errorf("envVarName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Test applyEnv gives command line flags precedence
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	workers := fs.Int("workers", 10, "")
+	quiet := fs.Bool("quiet", false, "")
+	retryPasses := fs.Int("retry-passes", 1, "")
+	version := fs.Bool("version", false, "")
+	var rates registry.RateLimits
+	fs.Var(rateFlag{&rates}, "rate", "")
+
+	if err := fs.Parse([]string{"-workers", "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"TAG_FINDER_WORKERS":      "50",
+		"TAG_FINDER_QUIET":        "true",
+		"TAG_FINDER_RETRY_PASSES": "0",
+		"TAG_FINDER_VERSION":      "true",
+		"TAG_FINDER_RATE":         "10/s,quay.io=2/s",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	set := flagsSet(fs)
+	if err := applyEnv(fs, set, lookup); err != nil {
+		t.Fatalf("applyEnv() error = %v", err)
+	}
+
+	if *workers != 3 {
+		t.Errorf("workers = %d, want 3 from the command line", *workers)
+	}
+	if !*quiet || *retryPasses != 0 {
+		t.Errorf("quiet = %v, retry-passes = %d, want values from the environment", *quiet, *retryPasses)
+	}
+	if *version {
+		t.Error("version should not be read from the environment")
+	}
+	if rates.Default != 10 || rates.Hosts["quay.io"] != 2 {
+		t.Errorf("rates = %+v", rates)
+	}
+	if !set["quiet"] || !set["rate"] || set["version"] {
+		t.Errorf("set = %v, want environment settings marked as set", set)
+	}
+
+	env = map[string]string{"TAG_FINDER_RETRY_PASSES": "lots"}
+	err := applyEnv(fs, map[string]bool{}, lookup)
+	if err == nil || !strings.Contains(err.Error(), "TAG_FINDER_RETRY_PASSES") {
+		t.Errorf("applyEnv() error = %v, want one naming the variable", err)
+	}
+}
+
+// Test documentEnvVars adds variable names to the help text
+func TestDocumentEnvVars(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("retry-passes", 1, "number of extra passes")
+	fs.Bool("version", false, "print version information")
+	documentEnvVars(fs)
+
+	if got := fs.Lookup("retry-passes").Usage; got != "number of extra passes [$TAG_FINDER_RETRY_PASSES]" {
+		t.Errorf("usage = %q", got)
+	}
+	if got := fs.Lookup("version").Usage; got != "print version information" {
+		t.Errorf("usage = %q", got)
+	}
+}
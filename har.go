@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), limited to the
+// fields needed to record and replay registry traffic
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	Cookies     []struct{}  `json:"cookies"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	Cookies     []struct{}  `json:"cookies"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// redactedValue replaces credentials in recordings
+const redactedValue = "[REDACTED]"
+
+// harSensitiveHeaders are redacted from recorded requests and responses
+var harSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// harSensitiveFields are redacted from recorded JSON bodies, e.g. token
+// endpoint responses
+var harSensitiveFields = []string{"token", "access_token", "refresh_token"}
+
+// harHeaders converts headers to HAR form, redacting credentials
+func harHeaders(h http.Header) []harHeader {
+	headers := []harHeader{}
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, value := range h[name] {
+			for _, sensitive := range harSensitiveHeaders {
+				if strings.EqualFold(name, sensitive) {
+					value = redactedValue
+				}
+			}
+			headers = append(headers, harHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// redactBody removes credentials from a JSON body. Anything that is not a
+// JSON object is returned unchanged.
+func redactBody(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	redacted := false
+	for _, name := range harSensitiveFields {
+		if _, ok := fields[name]; ok {
+			fields[name] = json.RawMessage(`"` + redactedValue + `"`)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return body
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// harRecorder captures every HTTP exchange made through its transports
+type harRecorder struct {
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// wrap returns a transport that records exchanges made through base
+func (r *harRecorder) wrap(base http.RoundTripper) http.RoundTripper {
+	return &recordTransport{base: base, recorder: r}
+}
+
+// save writes the recording to path
+func (r *harRecorder) save(path string) error {
+	r.mu.Lock()
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "oci-tag-finder", Version: version},
+		Entries: append([]harEntry{}, r.entries...),
+	}}
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+type recordTransport struct {
+	base     http.RoundTripper
+	recorder *harRecorder
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the body so it can be both recorded and passed on
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	content := harContent{Size: len(body), MimeType: resp.Header.Get("Content-Type")}
+	recorded := redactBody(body)
+	if utf8.Valid(recorded) {
+		content.Text = string(recorded)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(recorded)
+		content.Encoding = "base64"
+	}
+
+	query := []harHeader{}
+	params := req.URL.Query()
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		for _, value := range params[name] {
+			query = append(query, harHeader{Name: name, Value: value})
+		}
+	}
+
+	entry := harEntry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.Redacted(),
+			HTTPVersion: req.Proto,
+			Headers:     harHeaders(req.Header),
+			QueryString: query,
+			Cookies:     []struct{}{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: resp.Proto,
+			Headers:     harHeaders(resp.Header),
+			Cookies:     []struct{}{},
+			Content:     content,
+			HeadersSize: -1,
+			BodySize:    len(body),
+		},
+		Timings: harTimings{Wait: elapsed},
+	}
+
+	t.recorder.mu.Lock()
+	t.recorder.entries = append(t.recorder.entries, entry)
+	t.recorder.mu.Unlock()
+
+	return resp, nil
+}
+
+// replayTransport answers requests from a recording instead of the network.
+// Requests are matched by method and URL; repeated requests for the same URL
+// get the recorded responses in order, and the last one once they run out.
+type replayTransport struct {
+	mu        sync.Mutex
+	responses map[string][]harResponse
+	served    map[string]int
+}
+
+// loadHAR reads a recording made with -record
+func loadHAR(path string) (*replayTransport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	t := &replayTransport{
+		responses: make(map[string][]harResponse),
+		served:    make(map[string]int),
+	}
+	for _, entry := range har.Log.Entries {
+		key := entry.Request.Method + " " + entry.Request.URL
+		t.responses[key] = append(t.responses[key], entry.Response)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Redacted()
+
+	t.mu.Lock()
+	responses := t.responses[key]
+	i := min(t.served[key], len(responses)-1)
+	t.served[key]++
+	t.mu.Unlock()
+
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("replay: no recorded response for %s", key)
+	}
+	recorded := responses[i]
+
+	body := []byte(recorded.Content.Text)
+	if recorded.Content.Encoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(recorded.Content.Text); err != nil {
+			return nil, fmt.Errorf("replay: %s: %v", key, err)
+		}
+	}
+
+	header := make(http.Header)
+	for _, h := range recorded.Headers {
+		header.Add(h.Name, h.Value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, recorded.StatusText),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test redactBody function
+func TestRedactBody(t *testing.T) {
+	tests := map[string]string{
+		`{"token":"abc","expires_in":300}`:             `{"expires_in":300,"token":"[REDACTED]"}`,
+		`{"access_token":"abc","refresh_token":"def"}`: `{"access_token":"[REDACTED]","refresh_token":"[REDACTED]"}`,
+		`{"tags":["v1"]}`:                              `{"tags":["v1"]}`,
+		`not json`:                                     `not json`,
+	}
+	for input, want := range tests {
+		if got := string(redactBody([]byte(input))); got != want {
+			t.Errorf("redactBody(%s) = %s, want %s", input, got, want)
+		}
+	}
+}
+
+// Test a scan recorded with -record replays without the network and the
+// recording holds no credentials
+func TestRecordReplay(t *testing.T) {
+	digests := map[string]string{"v1": "sha256:aaaa", "v2": "sha256:bbbb", "latest": "sha256:bbbb"}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+		case r.Header.Get("Authorization") != "Bearer secret-token":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"v1", "v2", "latest"}})
+		default:
+			tag := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			w.Header().Set("Docker-Content-Digest", digests[tag])
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	// scan lists the tags and returns those matching sha256:bbbb
+	scan := func(opts clientOptions) []string {
+		t.Helper()
+		client := opts.newClient()
+		repo := registry.Repository{Registry: server.URL, Name: "test/repo"}
+		tags, err := client.ListTags(context.Background(), repo)
+		if err != nil {
+			t.Fatalf("ListTags() error = %v", err)
+		}
+		resultsChan := make(chan registry.TagInfo, len(tags))
+		go client.FetchDigests(context.Background(), repo, tags, resultsChan)
+		var matches []string
+		for info := range resultsChan {
+			if info.Err != nil {
+				t.Errorf("tag %s: %v", info.Tag, info.Err)
+			}
+			if info.Digest == "sha256:bbbb" {
+				matches = append(matches, info.Tag)
+			}
+		}
+		slices.Sort(matches)
+		return matches
+	}
+
+	recorder := &harRecorder{}
+	recorded := scan(clientOptions{workers: 2, recorder: recorder})
+	server.Close()
+
+	path := filepath.Join(t.TempDir(), "scan.har")
+	if err := recorder.save(path); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Errorf("recording contains the bearer token:\n%s", data)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("recording is not valid JSON: %v", err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) < 6 {
+		t.Errorf("unexpected recording: version %s, %d entries", har.Log.Version, len(har.Log.Entries))
+	}
+
+	// The server is gone, so everything must come from the recording
+	replay, err := loadHAR(path)
+	if err != nil {
+		t.Fatalf("loadHAR() error = %v", err)
+	}
+	replayed := scan(clientOptions{workers: 2, replay: replay})
+	if !slices.Equal(replayed, recorded) || !slices.Equal(recorded, []string{"latest", "v2"}) {
+		t.Errorf("replayed matches %v, recorded %v", replayed, recorded)
+	}
+
+	if _, err := replay.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL+"/v2/other/tags/list", nil)); err == nil {
+		t.Error("expected an error for a request that was not recorded")
+	}
+}
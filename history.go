@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// historyCompactSize is the file size above which saving the history also
+// checks whether it is worth compacting
+const historyCompactSize = 1 << 20
+
+// historyRecord is a tag -> digest association and when it was seen
+type historyRecord struct {
+	Repository string    `json:"repository"` // host/name, as in registry.Repository.String
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// historyKey identifies an association
+type historyKey struct {
+	repository, tag, digest string
+}
+
+func (r historyRecord) key() historyKey {
+	return historyKey{r.Repository, r.Tag, r.Digest}
+}
+
+// historyStore keeps every tag -> digest observation in a JSON-lines file.
+// Observations are gathered in memory and appended by flush, one line per
+// association seen since the last flush; reading merges the lines of an
+// association into one record. Registries don't keep tag history, so this
+// is the only record of where a tag used to point.
+type historyStore struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	pending map[historyKey]*historyRecord // observed since the last flush
+}
+
+func newHistoryStore(path string) *historyStore {
+	return &historyStore{path: path, now: time.Now, pending: make(map[historyKey]*historyRecord)}
+}
+
+// defaultHistoryPath returns history.jsonl in the user's cache directory, or
+// "" if there is none
+func defaultHistoryPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "oci-tag-finder", "history.jsonl")
+}
+
+// observe records that tag pointed at digest just now. It is a
+// registry.Observer.
+func (h *historyStore) observe(repo registry.Repository, tag, digest string) {
+	now := h.now()
+	key := historyKey{repo.String(), tag, digest}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, ok := h.pending[key]; ok {
+		r.LastSeen = now
+		return
+	}
+	h.pending[key] = &historyRecord{Repository: key.repository, Tag: tag, Digest: digest, FirstSeen: now, LastSeen: now}
+}
+
+// flush appends the pending observations to the file, compacting it if it
+// has grown large with repeated associations. A nil store does nothing.
+func (h *historyStore) flush() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.pending) == 0 {
+		return nil
+	}
+
+	records := make([]historyRecord, 0, len(h.pending))
+	for _, r := range h.pending {
+		records = append(records, *r)
+	}
+	slices.SortFunc(records, compareHistory)
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		return err
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	clear(h.pending)
+
+	if info, err := os.Stat(h.path); err == nil && info.Size() > historyCompactSize {
+		return h.compactLocked(false)
+	}
+	return nil
+}
+
+// lock takes the history's lock, shared by every process writing the file,
+// and returns a function releasing it. The lock is held on a separate file
+// since compacting replaces the history file itself.
+func (h *historyStore) lock() (func(), error) {
+	f, err := os.OpenFile(h.path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("locking %s: %v", h.path, err)
+	}
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+	}, nil
+}
+
+// compact rewrites the file with one line per association, if that would
+// at least halve it or force is set
+func (h *historyStore) compact(force bool) error {
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return h.compactLocked(force)
+}
+
+// compactLocked is compact for a caller holding the lock, so no other
+// process appends between reading the file and replacing it
+func (h *historyStore) compactLocked(force bool) error {
+	records, lines, err := h.read()
+	if err != nil {
+		return err
+	}
+	if !force && lines < 2*len(records) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), ".history-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.path)
+}
+
+// read returns the merged records in the file, sorted, and the number of
+// lines they came from. A missing file has no records.
+func (h *historyStore) read() ([]historyRecord, int, error) {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	merged := make(map[historyKey]*historyRecord)
+	lines := 0
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var r historyRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("%s: line %d: %v", h.path, lines+1, err)
+		}
+		lines++
+
+		m, ok := merged[r.key()]
+		if !ok {
+			merged[r.key()] = &r
+			continue
+		}
+		if r.FirstSeen.Before(m.FirstSeen) {
+			m.FirstSeen = r.FirstSeen
+		}
+		if r.LastSeen.After(m.LastSeen) {
+			m.LastSeen = r.LastSeen
+		}
+	}
+
+	records := make([]historyRecord, 0, len(merged))
+	for _, r := range merged {
+		records = append(records, *r)
+	}
+	slices.SortFunc(records, compareHistory)
+	return records, lines, nil
+}
+
+// lookup returns the associations of a repository, optionally only those of
+// a tag or of digests starting with digest, sorted by tag and first seen
+func (h *historyStore) lookup(repository, tag, digest string) ([]historyRecord, error) {
+	records, _, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(records, func(r historyRecord) bool {
+		return r.Repository != repository ||
+			(tag != "" && r.Tag != tag) ||
+			(digest != "" && !strings.HasPrefix(r.Digest, digest))
+	}), nil
+}
+
+// compareHistory orders records by repository, tag and first sighting
+func compareHistory(a, b historyRecord) int {
+	return cmp.Or(
+		strings.Compare(a.Repository, b.Repository),
+		strings.Compare(a.Tag, b.Tag),
+		a.FirstSeen.Compare(b.FirstSeen),
+		strings.Compare(a.Digest, b.Digest),
+	)
+}
+
+// writeHistory writes records as a table
+func writeHistory(w io.Writer, records []historyRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tDIGEST\tFIRST SEEN\tLAST SEEN")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Tag, r.Digest, r.FirstSeen.Local().Format(time.DateTime), r.LastSeen.Local().Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+// runHistory runs the history subcommand: it shows when each tag -> digest
+// association of an image was first and last seen by earlier scans,
+// optionally only for one tag or digest
+func runHistory(args []string, opts clientOptions, minPrefix int) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "write the records as a JSON array")
+	if err := fs.Parse(args); err != nil {
+		return exitFatal
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: tag-finder [flags] history [-json] <image> [tag|digest]")
+		return exitFatal
+	}
+	if opts.history == nil {
+		fmt.Fprintln(os.Stderr, "Error: history is disabled (-history-file is empty)")
+		return exitFatal
+	}
+
+	repo := registry.ParseRepository(strings.TrimPrefix(fs.Arg(0), "docker://"))
+	var tag, digest string
+	arg := fs.Arg(1)
+	if strings.Contains(arg, ":") {
+		// Tags cannot contain a colon, so this is a digest or a prefix of one
+		var err error
+		if digest, err = parseDigest(arg, minPrefix); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFatal
+		}
+	} else if d, err := parseDigest(arg, minPrefix); err == nil {
+		// Bare hex, as given to the scan itself, is taken as a digest
+		digest = d
+	} else {
+		tag = arg
+	}
+
+	records, err := opts.history.lookup(repo.String(), tag, digest)
+	if err == nil && len(records) == 0 && digest != "" && !strings.Contains(arg, ":") {
+		// No such digest, but hex strings such as commit hashes make tags too
+		records, err = opts.history.lookup(repo.String(), arg, "")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if records == nil {
+			records = []historyRecord{}
+		}
+		if err := enc.Encode(records); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFatal
+		}
+	} else if len(records) > 0 {
+		if err := writeHistory(os.Stdout, records); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFatal
+		}
+	}
+
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "No history for %s\n", strings.TrimSpace(repo.String()+" "+fs.Arg(1)))
+		return exitNoMatch
+	}
+	return exitMatch
+}
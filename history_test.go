@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"oci-tag-finder/internal/registrytest"
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test observations are merged per association across flushes
+func TestHistoryStore(t *testing.T) {
+	h := newHistoryStore(filepath.Join(t.TempDir(), "cache", "history.jsonl"))
+	clock := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return clock }
+	repo := registry.ParseRepository("ghcr.io/org/app")
+
+	h.observe(repo, "latest", "sha256:aaaa")
+	h.observe(repo, "v1", "sha256:aaaa")
+	clock = clock.Add(time.Hour)
+	h.observe(repo, "latest", "sha256:aaaa")
+	if err := h.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(time.Hour)
+	h.observe(repo, "latest", "sha256:bbbb")
+	h.observe(registry.ParseRepository("ghcr.io/org/other"), "latest", "sha256:aaaa")
+	if err := h.flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Hour)
+	h.observe(repo, "latest", "sha256:aaaa")
+	if err := h.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records, err := h.lookup("ghcr.io/org/app", "latest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []historyRecord{
+		{Repository: "ghcr.io/org/app", Tag: "latest", Digest: "sha256:aaaa", FirstSeen: start, LastSeen: start.Add(3 * time.Hour)},
+		{Repository: "ghcr.io/org/app", Tag: "latest", Digest: "sha256:bbbb", FirstSeen: start.Add(2 * time.Hour), LastSeen: start.Add(2 * time.Hour)},
+	}
+	if !slices.EqualFunc(records, want, equalHistory) {
+		t.Errorf("lookup(latest) = %+v, want %+v", records, want)
+	}
+
+	records, err = h.lookup("ghcr.io/org/app", "", "sha256:aa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Tag != "latest" || records[1].Tag != "v1" {
+		t.Errorf("lookup(sha256:aa) = %+v, want latest and v1", records)
+	}
+
+	// Compacting leaves one line per association and the same records
+	if err := h.compact(true); err != nil {
+		t.Fatal(err)
+	}
+	compacted, lines, err := h.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compacted) != 4 || lines != 4 {
+		t.Errorf("after compact: %d records in %d lines, want 4 in 4", len(compacted), lines)
+	}
+	if records, _ := h.lookup("ghcr.io/org/app", "latest", ""); !slices.EqualFunc(records, want, equalHistory) {
+		t.Errorf("lookup(latest) after compact = %+v", records)
+	}
+}
+
+// Test observations flushed by one store while another compacts the same
+// file are never lost
+func TestHistoryStore_ConcurrentCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	writer, compactor := newHistoryStore(path), newHistoryStore(path)
+	repo := registry.ParseRepository("ghcr.io/org/app")
+
+	const n = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range n {
+			writer.observe(repo, fmt.Sprintf("v%d", i), "sha256:aaaa")
+			if err := writer.flush(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if err := compactor.compact(true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if records, _, err := compactor.read(); err != nil || len(records) != n {
+		t.Errorf("read() = %d records, %v, want %d", len(records), err, n)
+	}
+}
+
+// equalHistory compares records, including times in different locations
+func equalHistory(a, b historyRecord) bool {
+	return a.key() == b.key() && a.FirstSeen.Equal(b.FirstSeen) && a.LastSeen.Equal(b.LastSeen)
+}
+
+// Test a missing file has no history and a corrupt one is an error
+func TestHistoryStore_Read(t *testing.T) {
+	dir := t.TempDir()
+	if records, err := newHistoryStore(filepath.Join(dir, "missing.jsonl")).lookup("ghcr.io/org/app", "", ""); err != nil || len(records) != 0 {
+		t.Errorf("missing file: %v, %v", records, err)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.jsonl")
+	if err := os.WriteFile(corrupt, []byte("{\"tag\":\"v1\"}\nnot json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newHistoryStore(corrupt).lookup("ghcr.io/org/app", "", ""); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("corrupt file error = %v, want it to name line 2", err)
+	}
+}
+
+// Test scans record what they see and the history command reports it
+func TestRunHistory(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	v1 := fake.PushImage("app", "v1")
+	fake.Tag("app", "latest", v1)
+
+	opts := fakeRegistryOptions(fake, "", "")
+	opts.history = newHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	captureStdout(t, func() {
+		runPlainMode(fake.Ref("app"), []string{v1}, opts, true)
+	})
+	v2 := fake.PushImage("app", "v2")
+	fake.Tag("app", "latest", v2)
+	captureStdout(t, func() {
+		runPlainMode(fake.Ref("app"), []string{v1}, opts, true)
+	})
+	if err := opts.history.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var code int
+	out := captureStdout(t, func() {
+		code = runHistory([]string{fake.Ref("app"), "latest"}, opts, defaultMinPrefix)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != exitMatch || len(lines) != 3 || !strings.HasPrefix(lines[0], "TAG") || !strings.Contains(lines[1], v1) || !strings.Contains(lines[2], v2) {
+		t.Errorf("runHistory(latest) = %d, output:\n%s", code, out)
+	}
+
+	out = captureStdout(t, func() {
+		code = runHistory([]string{"-json", fake.Ref("app"), v1[:19]}, opts, defaultMinPrefix)
+	})
+	var records []historyRecord
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	var tags []string
+	for _, r := range records {
+		tags = append(tags, r.Tag)
+	}
+	if code != exitMatch || !slices.Equal(tags, []string{"latest", "v1"}) {
+		t.Errorf("runHistory(digest) = %d, tags %v, want latest and v1", code, tags)
+	}
+
+	// Bare hex is a digest, unless no digest matches and a tag has that name
+	out = captureStdout(t, func() {
+		code = runHistory([]string{"-json", fake.Ref("app"), strings.TrimPrefix(v2, "sha256:")[:12]}, opts, defaultMinPrefix)
+	})
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output %q: %v", out, err)
+	}
+	if code != exitMatch || len(records) != 2 || records[0].Tag != "latest" || records[1].Tag != "v2" {
+		t.Errorf("runHistory(bare hex) = %d, records %+v, want latest and v2", code, records)
+	}
+	opts.history.observe(registry.ParseRepository(fake.Ref("app")), "0123456789abcdef", v1)
+	if err := opts.history.flush(); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() {
+		code = runHistory([]string{fake.Ref("app"), "0123456789abcdef"}, opts, defaultMinPrefix)
+	})
+	if code != exitMatch || !strings.Contains(out, "0123456789abcdef") {
+		t.Errorf("runHistory(hex tag) = %d, output:\n%s", code, out)
+	}
+
+	if code := runHistory([]string{fake.Ref("app"), "missing"}, opts, defaultMinPrefix); code != exitNoMatch {
+		t.Errorf("runHistory(missing) = %d, want %d", code, exitNoMatch)
+	}
+	opts.history = nil
+	if code := runHistory([]string{fake.Ref("app")}, opts, defaultMinPrefix); code != exitFatal {
+		t.Errorf("runHistory() without a store = %d, want %d", code, exitFatal)
+	}
+}
@@ -0,0 +1,514 @@
+// Package registrytest provides an in-process fake OCI registry for tests
+// and demos. It serves the distribution API endpoints the registry client
+// uses: the /v2/ base, tag listing with pagination, manifests by tag or
+// digest, referrers, and a bearer token endpoint, over TLS like a real
+// registry. Faults such as 429s, 5xx responses and slow answers can be
+// injected, and tokens can be made to expire.
+//
+//	fake := registrytest.New(registrytest.WithAuth("robot", "secret"))
+//	defer fake.Close()
+//	digest := fake.PushImage("team/app", "v1")
+//	fake.Inject(registrytest.Fault{Path: "/manifests/v1", Status: 429, Times: 1})
+package registrytest
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media types of the manifests the registry stores
+const (
+	MediaTypeImage = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex = "application/vnd.oci.image.index.v1+json"
+)
+
+// Registry is a fake registry listening on a local TLS server. It is safe
+// for concurrent use, and may be changed while clients are using it.
+type Registry struct {
+	*httptest.Server
+
+	username, password string // required for tokens when set
+	auth               bool
+	tokenTTL           time.Duration // 0 means tokens never expire
+	pageSize           int           // most tags per page; 0 means no limit
+
+	mu       sync.Mutex
+	repos    map[string]*repository
+	tokens   map[string]grant // token -> what it allows
+	faults   []*Fault
+	requests []string
+	serial   int // makes every pushed manifest unique
+}
+
+// repository is the content of one repository
+type repository struct {
+	tags      map[string]string    // tag -> manifest digest
+	manifests map[string]*manifest // digest -> manifest
+}
+
+// manifest is a stored manifest
+type manifest struct {
+	mediaType    string
+	artifactType string
+	subject      string // digest of the manifest this one refers to, if any
+	body         []byte
+}
+
+// grant records what a token was issued for
+type grant struct {
+	repository string
+	expires    time.Time // zero means never
+}
+
+// Fault makes the registry misbehave for matching requests
+type Fault struct {
+	Path       string        // matches requests whose path contains it; empty matches all
+	Status     int           // status to answer with; 0 answers normally after Delay
+	RetryAfter time.Duration // sent as a Retry-After header, in whole seconds
+	Delay      time.Duration // wait before answering
+	Times      int           // number of requests affected; 0 means every one
+}
+
+// Option configures a Registry
+type Option func(*Registry)
+
+// WithAuth makes the registry require bearer tokens, obtained from its
+// token endpoint. When username is not empty, token requests must carry
+// it and password as basic auth.
+func WithAuth(username, password string) Option {
+	return func(r *Registry) {
+		r.auth = true
+		r.username, r.password = username, password
+	}
+}
+
+// WithTokenTTL makes tokens expire ttl after they were issued
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(r *Registry) { r.tokenTTL = ttl }
+}
+
+// WithPageSize limits tag list pages to n tags, whatever the client asks for
+func WithPageSize(n int) Option {
+	return func(r *Registry) { r.pageSize = n }
+}
+
+// New starts a fake registry; call Close when done with it
+func New(opts ...Option) *Registry {
+	r := &Registry{
+		repos:  make(map[string]*repository),
+		tokens: make(map[string]grant),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Host returns the registry's host:port, as used in image references
+func (r *Registry) Host() string {
+	return r.Listener.Addr().String()
+}
+
+// Ref returns the image reference of a repository on the registry
+func (r *Registry) Ref(name string) string {
+	return r.Host() + "/" + name
+}
+
+// CertPool returns a pool trusting the registry's TLS certificate
+func (r *Registry) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(r.Certificate())
+	return pool
+}
+
+// repo returns a repository, creating it on first use. r.mu must be held.
+func (r *Registry) repo(name string) *repository {
+	if r.repos[name] == nil {
+		r.repos[name] = &repository{tags: make(map[string]string), manifests: make(map[string]*manifest)}
+	}
+	return r.repos[name]
+}
+
+// push stores a manifest, tags it if tag is not empty, and returns its digest
+func (r *Registry) push(name, tag string, m *manifest) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(m.body))
+	repo := r.repo(name)
+	repo.manifests[digest] = m
+	if tag != "" {
+		repo.tags[tag] = digest
+	}
+	return digest
+}
+
+// nextSerial returns a number not used by any earlier manifest
+func (r *Registry) nextSerial() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.serial++
+	return r.serial
+}
+
+// PushImage stores a new, unique image manifest, tags it if tag is not
+// empty, and returns its digest
+func (r *Registry) PushImage(name, tag string) string {
+	body, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeImage,
+		"config":        descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: fmt.Sprintf("sha256:%064x", r.nextSerial()), Size: 2},
+		"layers":        []descriptor{},
+	})
+	return r.push(name, tag, &manifest{mediaType: MediaTypeImage, body: body})
+}
+
+// PushIndex stores an image index of the given manifests, tags it if tag is
+// not empty, and returns its digest
+func (r *Registry) PushIndex(name, tag string, digests ...string) string {
+	manifests := make([]descriptor, len(digests))
+	for i, d := range digests {
+		manifests[i] = descriptor{MediaType: MediaTypeImage, Digest: d, Size: 2}
+	}
+	body, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeIndex,
+		"manifests":     manifests,
+		"annotations":   map[string]string{"serial": strconv.Itoa(r.nextSerial())},
+	})
+	return r.push(name, tag, &manifest{mediaType: MediaTypeIndex, body: body})
+}
+
+// PushReferrer stores an untagged artifact manifest whose subject is the
+// manifest with digest subject, and returns its digest
+func (r *Registry) PushReferrer(name, subject, artifactType string) string {
+	body, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeImage,
+		"artifactType":  artifactType,
+		"config":        descriptor{MediaType: "application/vnd.oci.empty.v1+json", Digest: fmt.Sprintf("sha256:%064x", r.nextSerial()), Size: 2},
+		"layers":        []descriptor{},
+		"subject":       descriptor{MediaType: MediaTypeImage, Digest: subject, Size: 2},
+	})
+	return r.push(name, "", &manifest{mediaType: MediaTypeImage, artifactType: artifactType, subject: subject, body: body})
+}
+
+// Tag points tag at digest, creating or moving it. The digest need not have
+// been pushed; a placeholder manifest is served for it if not.
+func (r *Registry) Tag(name, tag, digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repo(name).tags[tag] = digest
+}
+
+// Untag deletes a tag
+func (r *Registry) Untag(name, tag string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.repo(name).tags, tag)
+}
+
+// Inject adds a fault. Faults are matched in the order they were added;
+// the first matching one with requests left applies.
+func (r *Registry) Inject(f Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, &f)
+}
+
+// ExpireTokens invalidates every token issued so far
+func (r *Registry) ExpireTokens() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.tokens)
+}
+
+// Requests returns the requests served so far, as "METHOD /path?query"
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+// Count returns the number of requests served whose path contains path
+func (r *Registry) Count(path string) int {
+	n := 0
+	for _, req := range r.Requests() {
+		if strings.Contains(req, path) {
+			n++
+		}
+	}
+	return n
+}
+
+// descriptor is an OCI content descriptor
+type descriptor struct {
+	MediaType    string `json:"mediaType"`
+	Digest       string `json:"digest"`
+	Size         int    `json:"size"`
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// fault returns the fault to apply to a request, if any, using it up
+func (r *Registry) fault(path string) *Fault {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, f := range r.faults {
+		if !strings.Contains(path, f.Path) {
+			continue
+		}
+		applied := *f
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				r.faults = slices.Delete(r.faults, i, i+1)
+			}
+		}
+		return &applied
+	}
+	return nil
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.RequestURI())
+	r.mu.Unlock()
+
+	if f := r.fault(req.URL.Path); f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		if f.Status != 0 {
+			if f.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(f.RetryAfter.Seconds())))
+			}
+			writeError(w, f.Status, "UNAVAILABLE", "injected fault")
+			return
+		}
+	}
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	name, kind, ref := parsePath(strings.TrimPrefix(req.URL.Path, "/v2/"))
+	if !r.authorized(req, name) {
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, r.URL)
+		if name != "" {
+			challenge += fmt.Sprintf(`,scope="repository:%s:pull"`, name)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	switch kind {
+	case "":
+		w.WriteHeader(http.StatusOK)
+	case "tags":
+		r.serveTags(w, req, name)
+	case "manifests":
+		r.serveManifest(w, req, name, ref)
+	case "referrers":
+		r.serveReferrers(w, req, name, ref)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// parsePath splits the part of a /v2/ path after the prefix into the
+// repository, the kind of endpoint and the reference, if any
+func parsePath(path string) (name, kind, ref string) {
+	if name, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		return name, "tags", ""
+	}
+	for _, k := range []string{"manifests", "referrers"} {
+		if i := strings.LastIndex(path, "/"+k+"/"); i > 0 {
+			return path[:i], k, path[i+len(k)+2:]
+		}
+	}
+	if path == "" {
+		return "", "", ""
+	}
+	return path, "unknown", ""
+}
+
+// authorized reports whether a request may access a repository
+func (r *Registry) authorized(req *http.Request, name string) bool {
+	if !r.auth {
+		return true
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.tokens[token]
+	if !ok || (!g.expires.IsZero() && time.Now().After(g.expires)) {
+		return false
+	}
+	return name == "" || g.repository == name
+}
+
+// serveToken issues a token for the scope asked for
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != r.username || pass != r.password {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+			return
+		}
+	}
+
+	// scope is repository:<name>:pull
+	var name string
+	if scope := req.URL.Query().Get("scope"); scope != "" {
+		name = strings.TrimSuffix(strings.TrimPrefix(scope, "repository:"), ":pull")
+	}
+
+	r.mu.Lock()
+	r.serial++
+	token := fmt.Sprintf("token-%d", r.serial)
+	g := grant{repository: name}
+	if r.tokenTTL > 0 {
+		g.expires = time.Now().Add(r.tokenTTL)
+	}
+	r.tokens[token] = g
+	r.mu.Unlock()
+
+	writeJSON(w, "application/json", map[string]any{"token": token, "expires_in": int(r.tokenTTL.Seconds())})
+}
+
+// serveTags serves a page of a repository's tags in lexical order, with a
+// Link header to the next page if there is one
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	r.mu.Lock()
+	repo, ok := r.repos[name]
+	var tags []string
+	if ok {
+		for tag := range repo.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	slices.Sort(tags)
+
+	if last := req.URL.Query().Get("last"); last != "" {
+		i, _ := slices.BinarySearch(tags, last)
+		for i < len(tags) && tags[i] <= last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	n, _ := strconv.Atoi(req.URL.Query().Get("n"))
+	if r.pageSize > 0 && (n <= 0 || n > r.pageSize) {
+		n = r.pageSize
+	}
+	if n > 0 && len(tags) > n {
+		tags = tags[:n]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, name, n, tags[n-1]))
+	}
+
+	writeJSON(w, "application/json", map[string]any{"name": name, "tags": tags})
+}
+
+// serveManifest serves a manifest by tag or digest
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	r.mu.Lock()
+	var (
+		digest string
+		m      *manifest
+	)
+	if repo, ok := r.repos[name]; ok {
+		digest = ref
+		if d, ok := repo.tags[ref]; ok {
+			digest = d
+		}
+		m = repo.manifests[digest]
+		if m == nil && digest != ref {
+			// A tag pointing at a digest that was never pushed
+			m = &manifest{mediaType: MediaTypeImage, body: []byte(`{"schemaVersion":2}`)}
+		}
+	}
+	r.mu.Unlock()
+	if m == nil {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	etag := `"` + digest + `"`
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(m.body)
+}
+
+// serveReferrers serves an index of the manifests whose subject is digest,
+// optionally filtered by artifact type
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, name, digest string) {
+	artifactType := req.URL.Query().Get("artifactType")
+
+	r.mu.Lock()
+	manifests := []descriptor{}
+	if repo, ok := r.repos[name]; ok {
+		for d, m := range repo.manifests {
+			if m.subject == digest && (artifactType == "" || m.artifactType == artifactType) {
+				manifests = append(manifests, descriptor{MediaType: m.mediaType, Digest: d, Size: len(m.body), ArtifactType: m.artifactType})
+			}
+		}
+	}
+	r.mu.Unlock()
+	slices.SortFunc(manifests, func(a, b descriptor) int { return strings.Compare(a.Digest, b.Digest) })
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	writeJSON(w, MediaTypeIndex, map[string]any{"schemaVersion": 2, "mediaType": MediaTypeIndex, "manifests": manifests})
+}
+
+// writeJSON writes v as the response body
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a distribution API error response
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,217 @@
+package registrytest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// do sends a request to the fake registry and returns the response, whose
+// body has been decoded into v if it is not nil
+func do(t *testing.T, r *Registry, method, path string, header http.Header, v any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, r.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := r.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+// Test tag listing pages through tags in order
+func TestTagsPagination(t *testing.T) {
+	r := New(WithPageSize(2))
+	defer r.Close()
+	for _, tag := range []string{"v3", "v1", "v5", "v2", "v4"} {
+		r.PushImage("team/app", tag)
+	}
+
+	var all []string
+	path := "/v2/team/app/tags/list"
+	for path != "" {
+		var page struct{ Tags []string }
+		resp := do(t, r, "GET", path, nil, &page)
+		if len(page.Tags) > 2 {
+			t.Errorf("page of %d tags, want at most 2", len(page.Tags))
+		}
+		all = append(all, page.Tags...)
+		path, _, _ = strings.Cut(strings.TrimPrefix(resp.Header.Get("Link"), "<"), ">")
+	}
+	if want := []string{"v1", "v2", "v3", "v4", "v5"}; !slices.Equal(all, want) {
+		t.Errorf("tags = %v, want %v", all, want)
+	}
+
+	if resp := do(t, r, "GET", "/v2/missing/tags/list", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown repository status = %d, want 404", resp.StatusCode)
+	}
+}
+
+// Test manifests are served by tag and digest, with conditional requests
+func TestManifests(t *testing.T) {
+	r := New()
+	defer r.Close()
+	image := r.PushImage("app", "v1")
+	index := r.PushIndex("app", "multi", image)
+	r.Tag("app", "latest", image)
+	r.Tag("app", "placeholder", "sha256:aaaa")
+
+	tests := []struct {
+		ref       string
+		digest    string
+		mediaType string
+	}{
+		{"v1", image, MediaTypeImage},
+		{"latest", image, MediaTypeImage},
+		{image, image, MediaTypeImage},
+		{"multi", index, MediaTypeIndex},
+		{"placeholder", "sha256:aaaa", MediaTypeImage},
+	}
+	for _, tt := range tests {
+		resp := do(t, r, "HEAD", "/v2/app/manifests/"+tt.ref, nil, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Docker-Content-Digest") != tt.digest || resp.Header.Get("Content-Type") != tt.mediaType {
+			t.Errorf("%s: status %d, digest %s, type %s", tt.ref, resp.StatusCode, resp.Header.Get("Docker-Content-Digest"), resp.Header.Get("Content-Type"))
+		}
+	}
+
+	var idx struct{ Manifests []descriptor }
+	do(t, r, "GET", "/v2/app/manifests/multi", nil, &idx)
+	if len(idx.Manifests) != 1 || idx.Manifests[0].Digest != image {
+		t.Errorf("index manifests = %+v", idx.Manifests)
+	}
+
+	resp := do(t, r, "GET", "/v2/app/manifests/v1", http.Header{"If-None-Match": {`"` + image + `"`}}, nil)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request status = %d, want 304", resp.StatusCode)
+	}
+
+	r.Untag("app", "v1")
+	if resp := do(t, r, "GET", "/v2/app/manifests/v1", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("untagged manifest status = %d, want 404", resp.StatusCode)
+	}
+}
+
+// Test referrers are listed by subject and filtered by artifact type
+func TestReferrers(t *testing.T) {
+	r := New()
+	defer r.Close()
+	image := r.PushImage("app", "v1")
+	sbom := r.PushReferrer("app", image, "application/spdx+json")
+	sig := r.PushReferrer("app", image, "application/vnd.dev.cosign.artifact.sig.v1+json")
+
+	var all struct{ Manifests []descriptor }
+	do(t, r, "GET", "/v2/app/referrers/"+image, nil, &all)
+	got := []string{all.Manifests[0].Digest, all.Manifests[1].Digest}
+	slices.Sort(got)
+	want := []string{sbom, sig}
+	slices.Sort(want)
+	if len(all.Manifests) != 2 || !slices.Equal(got, want) {
+		t.Errorf("referrers = %+v", all.Manifests)
+	}
+
+	var filtered struct{ Manifests []descriptor }
+	resp := do(t, r, "GET", "/v2/app/referrers/"+image+"?artifactType=application/spdx%2Bjson", nil, &filtered)
+	if len(filtered.Manifests) != 1 || filtered.Manifests[0].Digest != sbom || resp.Header.Get("OCI-Filters-Applied") != "artifactType" {
+		t.Errorf("filtered referrers = %+v", filtered.Manifests)
+	}
+}
+
+// Test the token flow, credentials, repository scoping and expiry
+func TestAuth(t *testing.T) {
+	r := New(WithAuth("robot", "secret"))
+	defer r.Close()
+	r.PushImage("app", "v1")
+	r.PushImage("other", "v1")
+
+	resp := do(t, r, "GET", "/v2/app/tags/list", nil, nil)
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), `scope="repository:app:pull"`) {
+		t.Fatalf("anonymous request: status %d, challenge %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	if resp := do(t, r, "GET", "/token?scope=repository:app:pull", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token without credentials status = %d, want 401", resp.StatusCode)
+	}
+
+	var token struct{ Token string }
+	basic := http.Header{"Authorization": {"Basic cm9ib3Q6c2VjcmV0"}} // robot:secret
+	do(t, r, "GET", "/token?scope=repository:app:pull", basic, &token)
+	bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+
+	if resp := do(t, r, "GET", "/v2/app/tags/list", bearer, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("authorized status = %d, want 200", resp.StatusCode)
+	}
+	if resp := do(t, r, "GET", "/v2/other/tags/list", bearer, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("other repository status = %d, want 401", resp.StatusCode)
+	}
+
+	r.ExpireTokens()
+	if resp := do(t, r, "GET", "/v2/app/tags/list", bearer, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expired token status = %d, want 401", resp.StatusCode)
+	}
+}
+
+// Test tokens expire after the TTL
+func TestTokenTTL(t *testing.T) {
+	r := New(WithAuth("", ""), WithTokenTTL(50*time.Millisecond))
+	defer r.Close()
+	r.PushImage("app", "v1")
+
+	var token struct{ Token string }
+	do(t, r, "GET", "/token?scope=repository:app:pull", nil, &token)
+	bearer := http.Header{"Authorization": {"Bearer " + token.Token}}
+
+	if resp := do(t, r, "GET", "/v2/app/manifests/v1", bearer, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("fresh token status = %d, want 200", resp.StatusCode)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if resp := do(t, r, "GET", "/v2/app/manifests/v1", bearer, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expired token status = %d, want 401", resp.StatusCode)
+	}
+}
+
+// Test injected faults apply to matching requests the given number of times
+func TestInject(t *testing.T) {
+	r := New()
+	defer r.Close()
+	r.PushImage("app", "v1")
+	r.Inject(Fault{Path: "/manifests/", Status: http.StatusTooManyRequests, RetryAfter: 2 * time.Second, Times: 2})
+	r.Inject(Fault{Path: "/tags/", Status: http.StatusBadGateway})
+
+	for i := range 3 {
+		resp := do(t, r, "GET", "/v2/app/manifests/v1", nil, nil)
+		want := http.StatusTooManyRequests
+		if i == 2 {
+			want = http.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("request %d status = %d, want %d", i, resp.StatusCode, want)
+		}
+		if want == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "2" {
+			t.Errorf("Retry-After = %q, want 2", resp.Header.Get("Retry-After"))
+		}
+	}
+	for range 2 {
+		if resp := do(t, r, "GET", "/v2/app/tags/list", nil, nil); resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("tags status = %d, want 502", resp.StatusCode)
+		}
+	}
+
+	if n := r.Count("/manifests/v1"); n != 3 {
+		t.Errorf("Count() = %d, want 3", n)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// inventory is the tag -> digest map of a repository from one scan
+type inventory struct {
+	digests map[string]string  // tag -> digest, for the tags checked successfully
+	sha512  map[string]string  // tag -> sha512 digest, if the client computes them
+	failed  []registry.TagInfo // tags whose digest could not be fetched
+	total   int                // tags listed
+	err     error              // why the scan stopped early, if it did
+}
+
+// scanInventory lists a repository's tags and fetches the digest of each,
+// starting on the first page while later ones are being listed
+func scanInventory(ctx context.Context, client *registry.Client, repo registry.Repository) inventory {
+	var listing registry.Listing
+	resultsChan := make(chan registry.TagInfo, client.Concurrency(repo)*2)
+	go client.FetchDigestsSeq(ctx, repo, listing.Tags(client.Tags(ctx, repo)), resultsChan)
+
+	inv := inventory{digests: make(map[string]string), sha512: make(map[string]string)}
+	for result := range resultsChan {
+		if result.Err != nil {
+			inv.failed = append(inv.failed, result)
+			continue
+		}
+		inv.digests[result.Tag] = result.Digest
+		if result.SHA512 != "" {
+			inv.sha512[result.Tag] = result.SHA512
+		}
+	}
+
+	inv.total, inv.err = listing.Count, listing.Err
+	if inv.err == nil {
+		inv.err = ctx.Err()
+	}
+	return inv
+}
+
+// complete reports whether every tag was listed and checked, so a tag
+// missing from the inventory is definitely not in the repository
+func (inv inventory) complete() bool {
+	return inv.err == nil && len(inv.failed) == 0 && len(inv.digests) == inv.total
+}
+
+// tags returns the tags checked successfully, sorted
+func (inv inventory) tags() []string {
+	return slices.Sorted(maps.Keys(inv.digests))
+}
+
+// matches returns the sorted tags matching each of the matcher's targets
+// that matched at least one tag
+func (inv inventory) matches(matcher *digestMatcher) map[string][]string {
+	matches := make(map[string][]string)
+	for _, tag := range inv.tags() {
+		for _, target := range matcher.match(inv.digests[tag], inv.sha512[tag]) {
+			matches[target] = append(matches[target], tag)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"oci-tag-finder/internal/registrytest"
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test scanInventory records digests, failures and whether the scan is complete
+func TestScanInventory(t *testing.T) {
+	fake := registrytest.New(registrytest.WithPageSize(2))
+	defer fake.Close()
+	v1 := fake.PushImage("app", "v1")
+	fake.Tag("app", "latest", v1)
+	v2 := fake.PushImage("app", "v2")
+
+	client := fakeRegistryOptions(fake, "", "").newClient()
+	repo := registry.ParseRepository(fake.Ref("app"))
+
+	inv := scanInventory(context.Background(), client, repo)
+	if !inv.complete() || inv.total != 3 || inv.digests["latest"] != v1 || inv.digests["v2"] != v2 {
+		t.Errorf("inventory = %+v, want 3 tags, complete", inv)
+	}
+	if tags := inv.tags(); !slices.Equal(tags, []string{"latest", "v1", "v2"}) {
+		t.Errorf("tags() = %v", tags)
+	}
+	matches := inv.matches(newDigestMatcher([]string{v1, v2[:19]}))
+	if !slices.Equal(matches[v1], []string{"latest", "v1"}) || !slices.Equal(matches[v2[:19]], []string{"v2"}) {
+		t.Errorf("matches() = %v", matches)
+	}
+
+	// A tag that keeps failing leaves the inventory incomplete
+	fake.Inject(registrytest.Fault{Path: "/manifests/v2", Status: http.StatusInternalServerError})
+	inv = scanInventory(context.Background(), client, repo)
+	if inv.complete() || len(inv.failed) != 1 || inv.failed[0].Tag != "v2" {
+		t.Errorf("inventory = %+v, want v2 failed", inv)
+	}
+
+	// So does a listing that fails
+	inv = scanInventory(context.Background(), client, registry.ParseRepository(fake.Ref("missing")))
+	if inv.complete() || registry.ErrorCategory(inv.err) != "not found" {
+		t.Errorf("inventory err = %v, want not found", inv.err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes container runtimes put in front of image IDs in pod status
+var imageIDPrefixes = []string{"docker-pullable://", "docker://"}
+
+// extractK8sRefs extracts every digest-pinned image reference from Kubernetes
+// objects: `kubectl get ... -o json` output, or one or more YAML documents
+// such as rendered manifests. It looks at "image" and "imageID" fields at any
+// depth, so pods, deployments, lists and container statuses all work.
+// References are returned de-duplicated, in the order they were found.
+func extractK8sRefs(r io.Reader) ([]batchRef, error) {
+	var refs []batchRef
+	seen := make(map[string]bool)
+
+	decoder := yaml.NewDecoder(r)
+	for {
+		var doc any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing manifests: %v", err)
+		}
+
+		walkK8sObject(doc, func(ref string) {
+			if seen[ref] {
+				return
+			}
+			seen[ref] = true
+
+			idx := strings.LastIndex(ref, "@")
+			refs = append(refs, batchRef{
+				Ref:    ref,
+				Image:  stripTag(ref[:idx]),
+				Digest: ref[idx+1:],
+			})
+		})
+	}
+
+	return refs, nil
+}
+
+// walkK8sObject calls found for each image@digest reference in v
+func walkK8sObject(v any, found func(ref string)) {
+	switch v := v.(type) {
+	case map[string]any:
+		image, _ := v["image"].(string)
+		imageID, _ := v["imageID"].(string)
+
+		if ref, ok := k8sImageRef(image, imageID); ok {
+			found(ref)
+		}
+		if imageID != "" {
+			// A pinned spec image may differ from what is actually running
+			if ref, ok := k8sImageRef(image, ""); ok {
+				found(ref)
+			}
+		}
+
+		// Visit keys in a stable order so results do not depend on map iteration
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			walkK8sObject(v[key], found)
+		}
+	case []any:
+		for _, child := range v {
+			walkK8sObject(child, found)
+		}
+	}
+}
+
+// k8sImageRef builds an image@digest reference from a container's image or,
+// when known, its status imageID. An imageID without a repository, such as
+// a bare sha256:..., is what runtimes report for images that have no repo
+// digest: it is the image config's ID, not a manifest digest, so it could
+// never match a tag and is skipped.
+func k8sImageRef(image, imageID string) (string, bool) {
+	if imageID != "" {
+		for _, prefix := range imageIDPrefixes {
+			imageID = strings.TrimPrefix(imageID, prefix)
+		}
+		if strings.Contains(imageID, "@") {
+			return imageID, true
+		}
+		return "", false
+	}
+
+	if strings.Contains(image, "@") {
+		return image, true
+	}
+	return "", false
+}
+
+// stripTag removes a trailing :tag from an image name, leaving a registry
+// port such as localhost:5000/app untouched
+func stripTag(image string) string {
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		return image[:idx]
+	}
+	return image
+}
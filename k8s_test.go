@@ -0,0 +1,124 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Test extractK8sRefs with kubectl JSON output
+func TestExtractK8sRefs_PodListJSON(t *testing.T) {
+	input := `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {
+      "kind": "Pod",
+      "spec": {
+        "containers": [{"name": "app", "image": "ghcr.io/org/app:1.2"}],
+        "initContainers": [{"name": "init", "image": "busybox@sha256:1111"}]
+      },
+      "status": {
+        "containerStatuses": [
+          {"name": "app", "image": "ghcr.io/org/app:1.2", "imageID": "ghcr.io/org/app@sha256:2222"}
+        ],
+        "initContainerStatuses": [
+          {"name": "init", "image": "busybox@sha256:1111", "imageID": "docker-pullable://busybox@sha256:1111"}
+        ]
+      }
+    },
+    {
+      "kind": "Pod",
+      "spec": {"containers": [{"name": "api", "image": "localhost:5000/team/api:v3"}]},
+      "status": {
+        "containerStatuses": [
+          {"name": "api", "image": "localhost:5000/team/api:v3", "imageID": "sha256:3333"},
+          {"name": "app", "image": "ghcr.io/org/app:1.2", "imageID": "ghcr.io/org/app@sha256:2222"}
+        ]
+      }
+    }
+  ]
+}`
+
+	refs, err := extractK8sRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("extractK8sRefs() error = %v", err)
+	}
+
+	got := make([]string, len(refs))
+	for i, ref := range refs {
+		got[i] = ref.Image + "@" + ref.Digest
+	}
+	slices.Sort(got)
+
+	// The bare sha256:3333 imageID is a config ID, not a manifest digest
+	want := []string{
+		"busybox@sha256:1111",
+		"ghcr.io/org/app@sha256:2222",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("extractK8sRefs() = %v, want %v", got, want)
+	}
+}
+
+// Test extractK8sRefs with multi-document YAML manifests
+func TestExtractK8sRefs_ManifestYAML(t *testing.T) {
+	input := `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          image: docker.io/library/nginx:1.25@sha256:aaaa
+        - name: sidecar
+          image: envoyproxy/envoy:v1.30
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: job
+              image: quay.io/org/job@sha256:bbbb
+`
+
+	refs, err := extractK8sRefs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("extractK8sRefs() error = %v", err)
+	}
+
+	want := []batchRef{
+		{Ref: "docker.io/library/nginx:1.25@sha256:aaaa", Image: "docker.io/library/nginx", Digest: "sha256:aaaa"},
+		{Ref: "quay.io/org/job@sha256:bbbb", Image: "quay.io/org/job", Digest: "sha256:bbbb"},
+	}
+	if !slices.Equal(refs, want) {
+		t.Errorf("extractK8sRefs() = %+v, want %+v", refs, want)
+	}
+}
+
+// Test extractK8sRefs with malformed input
+func TestExtractK8sRefs_Invalid(t *testing.T) {
+	if _, err := extractK8sRefs(strings.NewReader("{ not: [valid")); err == nil {
+		t.Error("extractK8sRefs() expected error for malformed input")
+	}
+}
+
+// Test stripTag function
+func TestStripTag(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                      "nginx",
+		"nginx:1.25":                 "nginx",
+		"localhost:5000/app":         "localhost:5000/app",
+		"localhost:5000/app:v1":      "localhost:5000/app",
+		"ghcr.io/org/app:2024-01-01": "ghcr.io/org/app",
+	}
+	for input, want := range tests {
+		if got := stripTag(input); got != want {
+			t.Errorf("stripTag(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// parseLogLevel parses a -log-level value
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: want debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// newLogger creates a structured text logger writing to w
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// openLog sets up logging for the -debug, -log-level and -log-file flags.
+// It returns a nil logger when logging is off. Without a log file, logs go
+// to stderr, except in TUI mode where they would corrupt the display: there
+// they go to a temporary file whose path is returned.
+func openLog(debug bool, level, path string, tui bool) (*slog.Logger, string, error) {
+	if !debug && level == "" {
+		return nil, "", nil
+	}
+	logLevel := slog.LevelDebug
+	if !debug {
+		var err error
+		if logLevel, err = parseLogLevel(level); err != nil {
+			return nil, "", err
+		}
+	}
+
+	switch {
+	case path != "":
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, "", err
+		}
+		return newLogger(f, logLevel), path, nil
+	case tui:
+		f, err := os.CreateTemp("", "oci-tag-finder-*.log")
+		if err != nil {
+			return nil, "", err
+		}
+		return newLogger(f, logLevel), f.Name(), nil
+	default:
+		return newLogger(os.Stderr, logLevel), "", nil
+	}
+}
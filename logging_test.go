@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test parseLogLevel function
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for input, want := range tests {
+		if got, err := parseLogLevel(input); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel(verbose) expected error")
+	}
+}
+
+// Test openLog chooses where logs go
+func TestOpenLog(t *testing.T) {
+	if logger, _, err := openLog(false, "", "", false); logger != nil || err != nil {
+		t.Errorf("openLog() with logging off = %v, %v", logger, err)
+	}
+	if _, _, err := openLog(false, "loud", "", false); err == nil {
+		t.Error("openLog() expected error for an invalid level")
+	}
+
+	// The TUI owns the terminal, so logs go to a temporary file
+	logger, path, err := openLog(true, "", "", true)
+	if err != nil || logger == nil || path == "" {
+		t.Fatalf("openLog() in TUI mode = %v, %q, %v", logger, path, err)
+	}
+	defer func() { _ = os.Remove(path) }()
+	logger.Debug("hello")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "msg=hello") {
+		t.Errorf("log file = %q", data)
+	}
+
+	file := filepath.Join(t.TempDir(), "scan.log")
+	logger, path, err = openLog(false, "warn", file, false)
+	if err != nil || path != file {
+		t.Fatalf("openLog() with a file = %q, %v", path, err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+	data, _ := os.ReadFile(file)
+	if strings.Contains(string(data), "dropped") || !strings.Contains(string(data), "kept") {
+		t.Errorf("log file = %q", data)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Changes reported by match events
+const (
+	changeMatched = "matched" // a scan found the tag pointing at the target digest
+	changeAdded   = "added"   // watch: the tag now points at the target digest
+	changeRemoved = "removed" // watch: the tag no longer points at it, or is gone
+)
+
+// Delivery settings for -webhook and -exec
+const (
+	webhookTimeout = 30 * time.Second
+	notifyBackoff  = time.Second // before the first retry, doubling after each
+)
+
+// matchEvent reports a tag matching a target digest, or in watch mode a tag
+// starting or stopping to match one
+type matchEvent struct {
+	Time   time.Time `json:"time"`
+	Image  string    `json:"image"`
+	Target string    `json:"target"` // the target digest, possibly a prefix
+	Tag    string    `json:"tag"`
+	Digest string    `json:"digest,omitempty"` // what the tag points at now, if it still exists
+	Change string    `json:"change"`
+}
+
+// notifier delivers match events
+type notifier interface {
+	notify(ctx context.Context, event matchEvent) error
+}
+
+// newNotifiers returns the notifiers for -webhook and -exec, each retried up
+// to retries more times when delivery fails
+func newNotifiers(webhook, command string, retries int) ([]notifier, error) {
+	var notifiers []notifier
+	if webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", webhook)
+		}
+		n := &webhookNotifier{url: webhook, client: &http.Client{Timeout: webhookTimeout}}
+		notifiers = append(notifiers, &retryNotifier{next: n, attempts: retries + 1, backoff: notifyBackoff})
+	}
+	if command != "" {
+		n := &execNotifier{command: command}
+		notifiers = append(notifiers, &retryNotifier{next: n, attempts: retries + 1, backoff: notifyBackoff})
+	}
+	return notifiers, nil
+}
+
+// writerNotifier writes events as lines of text or NDJSON
+type writerNotifier struct {
+	w          io.Writer
+	ndjson     bool
+	showTarget bool // prefix text lines with the target digest, when there are several
+}
+
+func (n *writerNotifier) notify(_ context.Context, event matchEvent) error {
+	if n.ndjson {
+		return json.NewEncoder(n.w).Encode(event)
+	}
+	if n.showTarget {
+		_, err := fmt.Fprintf(n.w, "%s %s %s\n", event.Change, event.Target, event.Tag)
+		return err
+	}
+	_, err := fmt.Fprintf(n.w, "%s %s\n", event.Change, event.Tag)
+	return err
+}
+
+// webhookNotifier POSTs every event as JSON to a URL. Any 2xx response
+// counts as delivered.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) notify(ctx context.Context, event matchEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s answered %s", n.url, resp.Status)
+	}
+	return nil
+}
+
+// execNotifier runs a shell command for every event, with the event's fields
+// in TAG_FINDER_EVENT_* environment variables and the event as JSON on stdin.
+// A non-zero exit status counts as a failed delivery.
+type execNotifier struct {
+	command string
+}
+
+func (n *execNotifier) notify(ctx context.Context, event matchEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.command)
+	cmd.Env = append(os.Environ(), eventEnv(event)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stderr // keep stdout for the matches themselves
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("exec hook: %w: %s", err, msg)
+		}
+		return fmt.Errorf("exec hook: %w", err)
+	}
+	return nil
+}
+
+// eventEnv returns the environment variables describing an event to an exec
+// hook
+func eventEnv(event matchEvent) []string {
+	return []string{
+		"TAG_FINDER_EVENT_CHANGE=" + event.Change,
+		"TAG_FINDER_EVENT_IMAGE=" + event.Image,
+		"TAG_FINDER_EVENT_TAG=" + event.Tag,
+		"TAG_FINDER_EVENT_DIGEST=" + event.Digest,
+		"TAG_FINDER_EVENT_TARGET=" + event.Target,
+		"TAG_FINDER_EVENT_TIME=" + event.Time.Format(time.RFC3339),
+	}
+}
+
+// retryNotifier retries failed deliveries, doubling the wait each time
+type retryNotifier struct {
+	next     notifier
+	attempts int
+	backoff  time.Duration
+}
+
+func (n *retryNotifier) notify(ctx context.Context, event matchEvent) error {
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		err := n.next.notify(ctx, event)
+		if err == nil || attempt >= n.attempts {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// dispatcher delivers events to notifiers in the background and in order, so
+// slow deliveries and retries do not hold up a scan. A nil dispatcher
+// discards events.
+type dispatcher struct {
+	ctx       context.Context
+	notifiers []notifier
+	queue     chan matchEvent
+	done      chan struct{}
+}
+
+// newDispatcher starts delivering events to notifiers until ctx ends. It
+// returns nil if there are no notifiers.
+func newDispatcher(ctx context.Context, notifiers []notifier) *dispatcher {
+	if len(notifiers) == 0 {
+		return nil
+	}
+	d := &dispatcher{
+		ctx:       ctx,
+		notifiers: notifiers,
+		queue:     make(chan matchEvent, 1024),
+		done:      make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for event := range d.queue {
+		for _, n := range d.notifiers {
+			if err := n.notify(d.ctx, event); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: delivering %s event for %s: %v\n", event.Change, event.Tag, err)
+			}
+		}
+	}
+}
+
+// send queues an event for delivery
+func (d *dispatcher) send(event matchEvent) {
+	if d != nil {
+		d.queue <- event
+	}
+}
+
+// close waits for queued events to be delivered
+func (d *dispatcher) close() {
+	if d != nil {
+		close(d.queue)
+		<-d.done
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test events are written as text lines or NDJSON
+func TestWriterNotifier(t *testing.T) {
+	event := matchEvent{Time: time.Unix(0, 0).UTC(), Image: "nginx", Target: "sha256:aaaa", Tag: "latest", Digest: "sha256:aaaa", Change: changeAdded}
+
+	tests := []struct {
+		name     string
+		notifier writerNotifier
+		want     string
+	}{
+		{"text", writerNotifier{}, "added latest\n"},
+		{"text with target", writerNotifier{showTarget: true}, "added sha256:aaaa latest\n"},
+		{"ndjson", writerNotifier{ndjson: true}, `{"time":"1970-01-01T00:00:00Z","image":"nginx","target":"sha256:aaaa","tag":"latest","digest":"sha256:aaaa","change":"added"}` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tt.notifier.w = &buf
+		if err := tt.notifier.notify(context.Background(), event); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: output = %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+// Test the webhook gets the event as JSON and non-2xx answers are failures
+func TestWebhookNotifier(t *testing.T) {
+	status := http.StatusNoContent
+	var got matchEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := &webhookNotifier{url: server.URL, client: server.Client()}
+	event := matchEvent{Image: "nginx", Target: "sha256:aaaa", Tag: "v1", Digest: "sha256:aaaa", Change: changeMatched}
+	if err := n.notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got != event {
+		t.Errorf("payload = %+v, want %+v", got, event)
+	}
+
+	status = http.StatusBadGateway
+	if err := n.notify(context.Background(), event); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("error = %v, want the 502 reported", err)
+	}
+}
+
+// Test the exec hook gets the event in its environment and on stdin, and
+// failures are reported
+func TestExecNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	event := matchEvent{Time: time.Unix(0, 0).UTC(), Image: "nginx", Target: "sha256:aa", Tag: "v1", Digest: "sha256:aaaa", Change: changeRemoved}
+
+	n := &execNotifier{command: `echo "$TAG_FINDER_EVENT_CHANGE $TAG_FINDER_EVENT_IMAGE $TAG_FINDER_EVENT_TAG $TAG_FINDER_EVENT_DIGEST $TAG_FINDER_EVENT_TARGET $TAG_FINDER_EVENT_TIME" > ` + out + `; cat >> ` + out}
+	if err := n.notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, stdin, _ := strings.Cut(string(data), "\n")
+	if want := "removed nginx v1 sha256:aaaa sha256:aa 1970-01-01T00:00:00Z"; env != want {
+		t.Errorf("hook environment = %q, want %q", env, want)
+	}
+	var got matchEvent
+	if err := json.Unmarshal([]byte(stdin), &got); err != nil || got != event {
+		t.Errorf("hook stdin = %s (%v)", stdin, err)
+	}
+
+	n = &execNotifier{command: "echo broken >&2; exit 3"}
+	if err := n.notify(context.Background(), event); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("failing hook error = %v, want it to include stderr", err)
+	}
+}
+
+// flakyNotifier fails a number of times before succeeding
+type flakyNotifier struct {
+	failures int
+	calls    int
+}
+
+func (n *flakyNotifier) notify(context.Context, matchEvent) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+// Test failed deliveries are retried up to the limit
+func TestRetryNotifier(t *testing.T) {
+	tests := []struct {
+		failures int
+		attempts int
+		wantErr  bool
+		want     int
+	}{
+		{0, 3, false, 1},
+		{2, 3, false, 3},
+		{3, 3, true, 3},
+		{1, 1, true, 1},
+	}
+	for _, tt := range tests {
+		flaky := &flakyNotifier{failures: tt.failures}
+		n := &retryNotifier{next: flaky, attempts: tt.attempts, backoff: time.Millisecond}
+		err := n.notify(context.Background(), matchEvent{})
+		if (err != nil) != tt.wantErr || flaky.calls != tt.want {
+			t.Errorf("%d failures, %d attempts: err = %v after %d calls, want %d calls", tt.failures, tt.attempts, err, flaky.calls, tt.want)
+		}
+	}
+}
+
+// Test the dispatcher delivers every event, in order, before close returns
+func TestDispatcher(t *testing.T) {
+	var buf bytes.Buffer
+	d := newDispatcher(context.Background(), []notifier{&writerNotifier{w: &buf}})
+	for _, tag := range []string{"a", "b", "c"} {
+		d.send(matchEvent{Tag: tag, Change: changeMatched})
+	}
+	d.close()
+	if want := "matched a\nmatched b\nmatched c\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	// Without notifiers there is nothing to do
+	d = newDispatcher(context.Background(), nil)
+	d.send(matchEvent{})
+	d.close()
+}
+
+// Test newNotifiers validates the webhook URL
+func TestNewNotifiers(t *testing.T) {
+	if n, err := newNotifiers("https://hooks.example.com/tags", "true", 2); err != nil || len(n) != 2 {
+		t.Errorf("newNotifiers() = %v, %v", n, err)
+	}
+	for _, bad := range []string{"hooks.example.com", "ftp://hooks.example.com", "http://"} {
+		if _, err := newNotifiers(bad, "", 0); err == nil {
+			t.Errorf("newNotifiers(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credentials returns the username and password to send when requesting
+// bearer tokens for a registry host, or empty strings to request them
+// anonymously
+type Credentials func(host string) (username, password string)
+
+// Auth returns middleware implementing the registry token flow: a request
+// answered with a Bearer challenge is retried with a token from the
+// challenge's realm. Tokens are cached per registry and repository and sent
+// up front on later requests; a cached token that is rejected is replaced.
+// credentials may be nil. Token requests go through the rest of the chain.
+func Auth(credentials Credentials) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &authTransport{
+			next:        next,
+			client:      &http.Client{Transport: next},
+			credentials: credentials,
+			tokens:      make(map[string]string),
+		}
+	}
+}
+
+// authTransport is the RoundTripper built by Auth
+type authTransport struct {
+	next        http.RoundTripper
+	client      *http.Client // for token requests, following redirects
+	credentials Credentials
+	mu          sync.Mutex
+	tokens      map[string]string // registry URL + repository -> bearer token
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	repository := repositoryFromPath(req.URL.Path)
+	if repository == "" || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	registryURL := registryBase(req.URL.String())
+
+	sent := t.cachedToken(registryURL, repository)
+	resp, err := t.next.RoundTrip(withToken(req, sent))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	authHeader := resp.Header.Get("WWW-Authenticate")
+	if authHeader == "" {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	token, err := t.token(req.Context(), req.Header.Get("User-Agent"), authHeader, registryURL, repository, sent)
+	if err != nil {
+		return nil, &AuthError{Err: err}
+	}
+	return t.next.RoundTrip(withToken(req, token))
+}
+
+// withToken returns a copy of req carrying token, or req itself if token is empty
+func withToken(req *http.Request, token string) *http.Request {
+	if token == "" {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// repositoryFromPath returns the repository named by a /v2/ API path, or ""
+// for paths that are not scoped to a repository
+func repositoryFromPath(path string) string {
+	_, rest, ok := strings.Cut(path, "/v2/")
+	if !ok {
+		return ""
+	}
+	for _, marker := range []string{"/tags/", "/manifests/", "/blobs/", "/referrers/"} {
+		if i := strings.LastIndex(rest, marker); i > 0 {
+			return rest[:i]
+		}
+	}
+	return ""
+}
+
+// cachedToken returns the cached bearer token for a repository, if any
+func (t *authTransport) cachedToken(registryURL, repository string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens[tokenKey(registryURL, repository)]
+}
+
+// token returns a bearer token for a repository: the cached one, unless
+// that is the rejected token, or a new one from the challenge's realm
+func (t *authTransport) token(ctx context.Context, userAgent, authHeader, registryURL, repository, rejected string) (string, error) {
+	if token := t.cachedToken(registryURL, repository); token != "" && token != rejected {
+		return token, nil
+	}
+
+	token, err := t.fetchToken(ctx, userAgent, authHeader, registryURL, repository)
+	if err != nil {
+		return "", err
+	}
+
+	// Cache the token
+	t.mu.Lock()
+	t.tokens[tokenKey(registryURL, repository)] = token
+	t.mu.Unlock()
+
+	return token, nil
+}
+
+// fetchToken gets a bearer token from the realm named in a challenge,
+// anonymously unless there are credentials for the registry
+func (t *authTransport) fetchToken(ctx context.Context, userAgent, authHeader, registryURL, repository string) (string, error) {
+	// Parse WWW-Authenticate header
+	// Example: Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) < 2 || parts[0] != "Bearer" {
+		return "", fmt.Errorf("unsupported auth type: %s", parts[0])
+	}
+
+	params := make(map[string]string)
+	for _, part := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], "\"")
+		}
+	}
+
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("no realm in auth header")
+	}
+
+	// Build token request URL
+	tokenURL := realm + "?"
+	if service, ok := params["service"]; ok {
+		tokenURL += "service=" + service + "&"
+	}
+	if scope, ok := params["scope"]; ok {
+		tokenURL += "scope=" + scope
+	} else {
+		// If no scope in header, construct it
+		tokenURL += "scope=repository:" + repository + ":pull"
+	}
+
+	// Request token, with the registry's credentials if we have them
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if t.credentials != nil {
+		if username, password := t.credentials(urlHost(registryURL)); username != "" {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// Report the transport's error rather than the client's wrapping
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("token request failed with status %d", resp.StatusCode)}
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
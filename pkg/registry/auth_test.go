@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newAuthServers starts a token server issuing tokens from issue, and a
+// registry that accepts only the token valid reports as current
+func newAuthServers(t *testing.T, issue func(r *http.Request) map[string]string, valid func(token string) bool) (registry, tokenServer *httptest.Server) {
+	t.Helper()
+	tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(issue(r))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		_, _ = fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+		if !valid(token) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry.docker.io",scope="repository:library/nginx:pull"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(registry.Close)
+	return registry, tokenServer
+}
+
+// get sends a GET for url through rt and returns the status code
+func get(t *testing.T, rt http.RoundTripper, url string) int {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip(%s) error = %v", url, err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+// Test Auth fetches a token on a challenge and then sends it up front
+func TestAuth(t *testing.T) {
+	var tokenRequests atomic.Int32
+	registry, _ := newAuthServers(t, func(r *http.Request) map[string]string {
+		tokenRequests.Add(1)
+		// Verify service and scope parameters and credentials
+		if r.URL.Query().Get("service") != "registry.docker.io" {
+			t.Errorf("expected service=registry.docker.io, got %s", r.URL.Query().Get("service"))
+		}
+		if scope := r.URL.Query().Get("scope"); scope != "repository:library/nginx:pull" {
+			t.Errorf("expected scope=repository:library/nginx:pull, got %s", scope)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+			t.Errorf("token request basic auth = %q, %q, %v", user, pass, ok)
+		}
+		return map[string]string{"token": "test-token-123"}
+	}, func(token string) bool { return token == "test-token-123" })
+
+	credentials := func(host string) (string, string) {
+		if host != urlHost(registry.URL) {
+			t.Errorf("credentials requested for %s", host)
+		}
+		return "robot", "secret"
+	}
+	rt := Chain(http.DefaultTransport, Auth(credentials))
+
+	for range 2 {
+		if code := get(t, rt, registry.URL+"/v2/library/nginx/manifests/latest"); code != http.StatusOK {
+			t.Errorf("status = %d, want 200", code)
+		}
+	}
+	if n := tokenRequests.Load(); n != 1 {
+		t.Errorf("token requests = %d, want 1 (cached)", n)
+	}
+}
+
+// Test Auth accepts the access_token response field
+func TestAuth_AccessToken(t *testing.T) {
+	registry, _ := newAuthServers(t, func(*http.Request) map[string]string {
+		return map[string]string{"access_token": "access-token-456"}
+	}, func(token string) bool { return token == "access-token-456" })
+
+	rt := Chain(http.DefaultTransport, Auth(nil))
+	if code := get(t, rt, registry.URL+"/v2/test/tags/list"); code != http.StatusOK {
+		t.Errorf("status = %d, want 200", code)
+	}
+}
+
+// Test Auth replaces a cached token once the registry rejects it
+func TestAuth_ExpiredToken(t *testing.T) {
+	var generation atomic.Int32
+	registry, _ := newAuthServers(t, func(*http.Request) map[string]string {
+		return map[string]string{"token": fmt.Sprintf("token-%d", generation.Add(1))}
+	}, func(token string) bool { return token == fmt.Sprintf("token-%d", generation.Load()) })
+
+	rt := Chain(http.DefaultTransport, Auth(nil))
+	url := registry.URL + "/v2/library/nginx/manifests/latest"
+	if code := get(t, rt, url); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+
+	// Expire the cached token
+	generation.Add(1)
+	if code := get(t, rt, url); code != http.StatusOK {
+		t.Errorf("status after expiry = %d, want 200", code)
+	}
+	if g := generation.Load(); g != 3 {
+		t.Errorf("token generation = %d, want 3 (one refresh)", g)
+	}
+}
+
+// Test Auth reports token request failures as an *AuthError, classed as an
+// auth failure only when the token endpoint refused the credentials
+func TestAuth_TokenFailure(t *testing.T) {
+	tests := []struct {
+		status    int
+		auth      bool
+		category  string
+		transient bool
+	}{
+		{http.StatusForbidden, true, "auth", false},
+		{http.StatusUnauthorized, true, "auth", false},
+		{http.StatusServiceUnavailable, false, "server error", true},
+		{http.StatusTooManyRequests, false, "throttled", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer tokenServer.Close()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, tokenServer.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+			defer server.Close()
+
+			client := NewClient(WithRequestRetries(0, 0))
+			_, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "repo"}, "latest")
+			if _, ok := err.(*AuthError); !ok {
+				t.Fatalf("ManifestDigest() error = %T %v, want *AuthError", err, err)
+			}
+			if IsAuthError(err) != tt.auth || ErrorCategory(err) != tt.category || IsTransient(err) != tt.transient {
+				t.Errorf("auth = %v, category = %q, transient = %v, want %v, %q, %v",
+					IsAuthError(err), ErrorCategory(err), IsTransient(err), tt.auth, tt.category, tt.transient)
+			}
+		})
+	}
+}
+
+// Test repositoryFromPath function
+func TestRepositoryFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v2/library/nginx/tags/list", "library/nginx"},
+		{"/v2/team/app/manifests/v1", "team/app"},
+		{"/v2/app/manifests/sha256:abc", "app"},
+		{"/mirror/v2/org/app/referrers/sha256:abc", "org/app"},
+		{"/v2/", ""},
+		{"/token", ""},
+	}
+
+	for _, tt := range tests {
+		if got := repositoryFromPath(tt.path); got != tt.want {
+			t.Errorf("repositoryFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package registry finds the tags of an OCI image that point at a digest,
+// using the Docker Registry HTTP API v2.
+//
+// A Client lists a repository's tags and fetches manifest digests with
+// bounded concurrency, retries, rate limiting and per-registry settings:
+//
+//	client := registry.NewClient(registry.WithConcurrency(20))
+//	repo := registry.ParseRepository("docker.io/library/nginx")
+//	tags, err := client.FindTagsByDigest(ctx, repo, "sha256:...")
+//
+// Exported identifiers are a stable API: they will not change incompatibly
+// without a new major version.
+package registry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Defaults used when the corresponding option is not given
+const (
+	DefaultConcurrency    = 10
+	DefaultConnectTimeout = 10 * time.Second // TCP connect and TLS handshake
+	DefaultRequestTimeout = 30 * time.Second // a whole request, including reading the body
+	DefaultUserAgent      = "oci-tag-finder"
+)
+
+// Client handles HTTP requests to Docker Registry API v2. It is safe for
+// concurrent use, and is best shared: bearer tokens are cached, and the
+// concurrency and rate limits apply across everything it does. Requests go
+// through a chain of middlewares, which WithMiddleware extends.
+type Client struct {
+	httpClient   *http.Client
+	workers      int
+	retryPasses  int                       // extra passes over failed tags after the main pass
+	retryWorkers int                       // concurrency of the retry passes
+	limiter      requestLimiter            // shared budget of in-flight requests
+	hosts        map[string]*hostConfig    // registry host -> per-registry settings
+	hostLimiters map[string]requestLimiter // registry host -> its own budget, replacing limiter
+	logger       *slog.Logger
+	userAgent    string
+	observer     Observer // nil means none
+	sha512       bool     // compute sha512 digests from manifest content
+}
+
+// NewClient creates a registry client configured by opts
+func NewClient(opts ...Option) *Client {
+	cfg := &config{
+		concurrency:    DefaultConcurrency,
+		retryPasses:    1,
+		connectTimeout: DefaultConnectTimeout,
+		requestTimeout: DefaultRequestTimeout,
+		userAgent:      DefaultUserAgent,
+		hosts:          make(map[string]*hostConfig),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	c := &Client{
+		workers:      cfg.concurrency,
+		retryPasses:  cfg.retryPasses,
+		retryWorkers: cfg.retryWorkers,
+		limiter:      newLimiter(cfg.concurrency, cfg.adaptive),
+		hosts:        cfg.hosts,
+		hostLimiters: make(map[string]requestLimiter),
+		logger:       cfg.logger,
+		userAgent:    cfg.userAgent,
+		observer:     cfg.observer,
+		sha512:       cfg.sha512,
+	}
+	if c.retryWorkers == 0 {
+		c.retryWorkers = max(1, cfg.concurrency/2)
+	}
+	if c.logger == nil {
+		c.logger = discardLogger
+	}
+	for host, hc := range cfg.hosts {
+		if hc.concurrency > 0 {
+			c.hostLimiters[host] = newLimiter(hc.concurrency, cfg.adaptive)
+		}
+	}
+
+	c.httpClient = &http.Client{Transport: cfg.newTransport()}
+	return c
+}
+
+// newLimiter creates the request budget for up to n requests in flight
+func newLimiter(n int, adaptive bool) requestLimiter {
+	if adaptive {
+		// Start low and let the limiter find what the registry can take
+		return newAIMDLimiter(max(1, n/4), n)
+	}
+	return newFixedLimiter(n)
+}
+
+// newTransport builds the transport chain, outermost first: caching, auth,
+// WithMiddleware middlewares, request retries, rate limiting, the request
+// timeout, logging, wrappers, and the network (or the WithTransport
+// replacement)
+func (cfg *config) newTransport() http.RoundTripper {
+	rt := cfg.transport
+	if rt == nil {
+		transport := &http.Transport{
+			Proxy:               proxyFunc(cfg.proxy),
+			DialContext:         (&net.Dialer{Timeout: cfg.connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout: cfg.connectTimeout,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+		}
+		rt = transport
+
+		caTransports := make(map[string]http.RoundTripper)
+		for host, hc := range cfg.hosts {
+			if hc.rootCAs != nil {
+				caTransports[host] = withRootCAs(transport, hc.rootCAs)
+			}
+		}
+		if len(caTransports) > 0 {
+			rt = &hostTransport{base: transport, hosts: caTransports}
+		}
+	}
+
+	for _, wrap := range cfg.wrappers {
+		rt = wrap(rt)
+	}
+	if cfg.logger != nil {
+		rt = &logTransport{base: rt, logger: cfg.logger}
+	}
+	if cfg.requestTimeout > 0 {
+		rt = &timeoutTransport{base: rt, timeout: cfg.requestTimeout}
+	}
+
+	var chain []Middleware
+	if cfg.cache != nil {
+		chain = append(chain, Caching(cfg.cache))
+	}
+	chain = append(chain, Auth(cfg.credentials))
+	chain = append(chain, cfg.middlewares...)
+	if cfg.retries > 0 {
+		logger := cfg.logger
+		if logger == nil {
+			logger = discardLogger
+		}
+		chain = append(chain, retry(cfg.retries, cfg.retryBackoff, logger))
+	}
+	if cfg.rates.enabled() {
+		chain = append(chain, cfg.rates.middleware)
+	}
+	return Chain(rt, chain...)
+}
+
+// newRequest creates a GET request carrying the client's user agent
+func (c *Client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	return req, nil
+}
+
+// do sends req through the client's transport chain. An *AuthError from
+// the auth middleware is returned as it is, not wrapped in a *url.Error.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return nil, authErr
+	}
+	return resp, err
+}
+
+// tokenKey returns the token cache key for a repository on a registry.
+// Tokens are scoped per repository, so a client shared across repositories
+// must not reuse one repository's token for another.
+func tokenKey(registryURL, repository string) string {
+	return registryURL + "/" + repository
+}
+
+// registryBase extracts the registry base URL from a /v2/ API URL
+func registryBase(url string) string {
+	if idx := strings.Index(url, "/v2/"); idx >= 0 {
+		return url[:idx]
+	}
+	return url
+}
+
+// limiterFor returns the request budget for a registry: its own if it was
+// given one with WithHostConcurrency, otherwise the client's shared one
+func (c *Client) limiterFor(registryURL string) requestLimiter {
+	if l, ok := c.hostLimiters[urlHost(registryURL)]; ok {
+		return l
+	}
+	return c.limiter
+}
+
+// workersFor returns the number of concurrent requests to make to a registry
+func (c *Client) workersFor(registryURL string) int {
+	if hc, ok := c.hosts[urlHost(registryURL)]; ok && hc.concurrency > 0 {
+		return hc.concurrency
+	}
+	return c.workers
+}
+
+// Concurrency returns the number of requests currently allowed in flight to
+// the repository's registry. It only changes with adaptive concurrency.
+func (c *Client) Concurrency(repo Repository) int {
+	return c.limiterFor(repo.Registry).limit()
+}
+
+// endpoints returns the base URLs to try for a registry: its mirrors, in
+// order, then the registry itself
+func (c *Client) endpoints(registryURL string) []string {
+	var mirrors []string
+	if hc, ok := c.hosts[urlHost(registryURL)]; ok {
+		mirrors = hc.mirrors
+	}
+	return append(slices.Clone(mirrors), registryURL)
+}
+
+// withLimit runs a request to a registry within its request budget,
+// reporting the outcome so adaptive limiters can tune themselves
+func (c *Client) withLimit(ctx context.Context, registryURL string, request func() error) error {
+	limiter := c.limiterFor(registryURL)
+	if err := limiter.acquire(ctx); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := request()
+	limiter.release(time.Since(start), err)
+	return err
+}
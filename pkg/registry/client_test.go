@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test every request carries the configured user agent
+func TestUserAgent(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		agents []string
+	)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/token":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "test-token"})
+		case r.Header.Get("Authorization") == "":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/tags/list"):
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"v1"}})
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1), WithUserAgent("oci-tag-finder/1.2.3"))
+	if _, err := client.ListTags(context.Background(), Repository{Registry: server.URL, Name: "test/repo"}); err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+	if _, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "test/repo"}, "v1"); err != nil {
+		t.Fatalf("ManifestDigest() error = %v", err)
+	}
+
+	want := "oci-tag-finder/1.2.3"
+	if len(agents) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(agents))
+	}
+	for _, agent := range agents {
+		if agent != want {
+			t.Errorf("User-Agent = %q, want %q", agent, want)
+		}
+	}
+}
+
+// Test the per-request timeout applies to each request
+func TestNewClient_RequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1), WithRequestTimeout(50*time.Millisecond))
+	if _, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "test/repo"}, "fast"); err != nil {
+		t.Errorf("ManifestDigest(fast) error = %v", err)
+	}
+	_, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "test/repo"}, "slow")
+	if err == nil {
+		t.Fatal("ManifestDigest(slow) expected a timeout")
+	}
+	if category := ErrorCategory(err); category != "network" {
+		t.Errorf("ErrorCategory() = %q, want network", category)
+	}
+}
+
+// Test per-registry options: the registry's CA is trusted, its credentials
+// are sent when requesting a token and it gets its own request budget
+func TestNewClient_HostSettings(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "robot" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "private-token"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer private-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	client := NewClient(
+		WithConcurrency(10),
+		WithHostConcurrency(host, 2),
+		WithCredentials(host, "robot", "secret"),
+		WithRootCAs(host, pool),
+	)
+
+	repo := Repository{Registry: server.URL, Name: "team/app"}
+	digest, err := client.ManifestDigest(context.Background(), repo, "v1")
+	if err != nil || digest != "sha256:aaaa" {
+		t.Errorf("ManifestDigest() = %q, %v", digest, err)
+	}
+	if client.workersFor(server.URL) != 2 || client.Concurrency(repo) != 2 {
+		t.Errorf("expected a per-registry budget of 2, got %d workers, limit %d",
+			client.workersFor(server.URL), client.Concurrency(repo))
+	}
+	if client.limiterFor("https://ghcr.io") != client.limiter {
+		t.Error("expected other registries to share the default budget")
+	}
+
+	// Without the CA the same request fails certificate verification
+	if _, err := NewClient().ManifestDigest(context.Background(), repo, "v1"); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+}
+
+// Test registry requests go through an authenticated proxy
+func TestNewClient_Proxy(t *testing.T) {
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	var proxied []string
+
+	// The proxy answers as the registry itself, since registry.test does not exist
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != wantAuth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxyServer.Close()
+
+	proxy, err := url.Parse(strings.Replace(proxyServer.URL, "http://", "http://user:secret@", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(WithProxy(proxy))
+
+	digest, err := client.ManifestDigest(context.Background(), Repository{Registry: "http://registry.test", Name: "team/app"}, "v1")
+	if err != nil || digest != "sha256:aaaa" {
+		t.Fatalf("ManifestDigest() = %q, %v", digest, err)
+	}
+	if len(proxied) != 1 || proxied[0] != "http://registry.test/v2/team/app/manifests/v1" {
+		t.Errorf("proxied requests = %v", proxied)
+	}
+}
@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// TagInfo represents the result of checking a tag
+type TagInfo struct {
+	Tag     string
+	Digest  string
+	SHA512  string // the manifest's sha512 digest, only computed with WithSHA512
+	Err     error
+	Retried bool // result comes from a retry pass after the tag first failed
+}
+
+// IncompleteError is returned by FindTagsByDigest when some tags could not
+// be checked, so a missing match is not definite
+type IncompleteError struct {
+	Failed []TagInfo // the tags that could not be checked, with their errors
+	Total  int       // the number of tags in the repository
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("%d of %d tags could not be checked", len(e.Failed), e.Total)
+}
+
+// FindTagsByDigest returns the tags of a repository whose manifest digest is
+// digest, compared case-insensitively. If some tags could not be checked the
+// matches found are returned with an *IncompleteError; if the listing fails
+// part way or ctx ends first they are returned with that error. Tags are
+// checked as each page of the listing arrives.
+func (c *Client) FindTagsByDigest(ctx context.Context, repo Repository, digest string) ([]string, error) {
+	var listing Listing
+	resultsChan := make(chan TagInfo, c.workers*2)
+	go c.FetchDigestsSeq(ctx, repo, listing.Tags(c.Tags(ctx, repo)), resultsChan)
+
+	var (
+		matches []string
+		failed  []TagInfo
+	)
+	for result := range resultsChan {
+		switch {
+		case result.Err != nil:
+			failed = append(failed, result)
+		case strings.EqualFold(result.Digest, digest):
+			matches = append(matches, result.Tag)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return matches, err
+	}
+	if listing.Err != nil {
+		return matches, listing.Err
+	}
+	if len(failed) > 0 {
+		return matches, &IncompleteError{Failed: failed, Total: listing.Count}
+	}
+	return matches, nil
+}
+
+// FetchDigests fetches digests for tags concurrently and sends one result
+// per tag to resultsChan, closing it when done. Tags that fail with a
+// transient error are held back and retried in further passes, with fewer
+// workers, once the main pass has finished. When ctx ends, tags not yet
+// checked get no result.
+func (c *Client) FetchDigests(ctx context.Context, repo Repository, tags []string, resultsChan chan<- TagInfo) {
+	c.FetchDigestsSeq(ctx, repo, slices.Values(tags), resultsChan)
+}
+
+// FetchDigestsSeq is like FetchDigests but takes the tags as a sequence,
+// which is consumed while the workers run: with a Listing over Tags, the
+// first page of a repository is being checked while later pages are still
+// being fetched. The sequence has been fully consumed by the time
+// resultsChan is closed.
+func (c *Client) FetchDigestsSeq(ctx context.Context, repo Repository, tags iter.Seq[string], resultsChan chan<- TagInfo) {
+	failed := c.fetchPass(ctx, repo, tags, c.workersFor(repo.Registry), c.retryPasses > 0, 0, resultsChan)
+
+	for pass := 1; pass <= c.retryPasses && len(failed) > 0 && ctx.Err() == nil; pass++ {
+		retryTags := make([]string, len(failed))
+		for i, f := range failed {
+			retryTags[i] = f.Tag
+		}
+		c.logger.Info("retrying failed tags", "repository", repo.Name, "pass", pass, "tags", len(retryTags))
+		failed = c.fetchPass(ctx, repo, slices.Values(retryTags), c.retryWorkers, pass < c.retryPasses, pass, resultsChan)
+	}
+
+	// Report anything still held back, e.g. when the scan was cancelled
+	for _, f := range failed {
+		resultsChan <- f
+	}
+	close(resultsChan)
+}
+
+// fetchPass runs one pass of the worker pool over tags; pass 0 is the main
+// pass and later ones are retries. With holdFailures, transient failures are
+// returned instead of being sent to resultsChan. The sequence is drained
+// into a queue as fast as it yields, so a slow pass never holds up listing.
+func (c *Client) fetchPass(ctx context.Context, repo Repository, tags iter.Seq[string], workers int, holdFailures bool, pass int, resultsChan chan<- TagInfo) []TagInfo {
+	jobs := newTagQueue()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []TagInfo
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer jobs.close()
+		for tag := range tags {
+			jobs.push(tag)
+		}
+	}()
+
+	// Start workers
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				tag, ok := jobs.pop()
+				if !ok {
+					return
+				}
+				digests, err := c.manifestDigests(ctx, repo, tag)
+				digest := digests.digest
+				if err != nil && ctx.Err() != nil {
+					// Cancelled: this and the remaining tags are left unchecked
+					return
+				}
+
+				info := TagInfo{Tag: tag, Digest: digest, SHA512: digests.sha512, Err: err, Retried: pass > 0}
+				switch {
+				case err == nil:
+					c.logger.Debug("checked tag", "repository", repo.Name, "tag", tag, "pass", pass, "digest", digest)
+					if c.observer != nil {
+						c.observer(repo, tag, digest)
+					}
+				case holdFailures && IsTransient(err):
+					c.logger.Info("tag failed, will retry", "repository", repo.Name, "tag", tag, "pass", pass, "error", err)
+				default:
+					c.logger.Warn("tag failed", "repository", repo.Name, "tag", tag, "pass", pass, "error", err)
+				}
+				if holdFailures && err != nil && IsTransient(err) {
+					mu.Lock()
+					failed = append(failed, info)
+					mu.Unlock()
+					continue
+				}
+				resultsChan <- info
+			}
+		}()
+	}
+
+	wg.Wait()
+	return failed
+}
+
+// Listing adapts a tag iterator such as Tags for FetchDigestsSeq, counting
+// the tags listed and keeping the error that ended the listing. Its fields
+// are safe to read once FetchDigestsSeq has closed its results channel.
+type Listing struct {
+	Count int   // the number of tags listed
+	Err   error // why the listing ended early, if it did
+}
+
+// Tags returns the tags as a plain sequence that ends at the first error
+func (l *Listing) Tags(tags iter.Seq2[string, error]) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for tag, err := range tags {
+			if err != nil {
+				l.Err = err
+				return
+			}
+			l.Count++
+			if !yield(tag) {
+				return
+			}
+		}
+	}
+}
+
+// tagQueue is an unbounded queue of tags shared by a pass's workers
+type tagQueue struct {
+	mu     sync.Mutex
+	ready  *sync.Cond
+	tags   []string
+	closed bool
+}
+
+func newTagQueue() *tagQueue {
+	q := &tagQueue{}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *tagQueue) push(tag string) {
+	q.mu.Lock()
+	q.tags = append(q.tags, tag)
+	q.mu.Unlock()
+	q.ready.Signal()
+}
+
+// close marks the end of the tags; workers drain what is left and stop
+func (q *tagQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.ready.Broadcast()
+}
+
+// pop waits for the next tag, returning false once the queue is closed and empty
+func (q *tagQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.tags) == 0 && !q.closed {
+		q.ready.Wait()
+	}
+	if len(q.tags) == 0 {
+		return "", false
+	}
+	tag := q.tags[0]
+	q.tags = q.tags[1:]
+	return tag, true
+}
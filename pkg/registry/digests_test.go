@@ -0,0 +1,381 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test FetchDigests worker pool
+func TestFetchDigests(t *testing.T) {
+	// Setup mock server for manifest endpoints
+	digestMap := map[string]string{
+		"tag1": "sha256:aaaa",
+		"tag2": "sha256:bbbb",
+		"tag3": "sha256:cccc",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Extract tag from URL path
+		parts := strings.Split(r.URL.Path, "/")
+		tag := parts[len(parts)-1]
+
+		if digest, ok := digestMap[tag]; ok {
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(3)) // 3 workers
+	ctx := context.Background()
+	resultsChan := make(chan TagInfo, 10)
+
+	tags := []string{"tag1", "tag2", "tag3"}
+	go client.FetchDigests(ctx, Repository{Registry: server.URL, Name: "repo"}, tags, resultsChan)
+
+	// Collect results
+	results := make(map[string]string)
+	for i := 0; i < len(tags); i++ {
+		info := <-resultsChan
+		if info.Err != nil {
+			t.Errorf("Unexpected error for tag %s: %v", info.Tag, info.Err)
+		}
+		results[info.Tag] = info.Digest
+	}
+
+	// Verify all tags processed
+	for tag, expectedDigest := range digestMap {
+		if got, ok := results[tag]; !ok {
+			t.Errorf("Tag %s not processed", tag)
+		} else if got != expectedDigest {
+			t.Errorf("Tag %s digest = %v, want %v", tag, got, expectedDigest)
+		}
+	}
+
+	// Verify channel is closed
+	select {
+	case _, ok := <-resultsChan:
+		if ok {
+			t.Error("Channel should be closed")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Error("Channel was not closed in time")
+	}
+}
+
+// Test FetchDigests with context cancellation
+func TestFetchDigests_Cancellation(t *testing.T) {
+	// Mock slow server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(1 * time.Second) // Simulate slow response
+		w.Header().Set("Docker-Content-Digest", "sha256:test")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	resultsChan := make(chan TagInfo, 10)
+
+	tags := make([]string, 100) // Many tags
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag%d", i)
+	}
+
+	go client.FetchDigests(ctx, Repository{Registry: server.URL, Name: "repo"}, tags, resultsChan)
+
+	// Cancel after brief period
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	// Collect what was processed
+	processed := 0
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-resultsChan:
+			if !ok {
+				// Channel closed as expected
+				if processed >= 100 {
+					t.Error("All tags were processed despite cancellation")
+				}
+				return
+			}
+			processed++
+		case <-timeout:
+			t.Error("Channel was not closed after cancellation")
+			return
+		}
+	}
+}
+
+// Test FetchDigests retries transient failures after the main pass
+func TestFetchDigests_RetryPass(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		tag := parts[len(parts)-1]
+
+		mu.Lock()
+		attempts[tag]++
+		n := attempts[tag]
+		mu.Unlock()
+
+		switch {
+		case tag == "missing":
+			w.WriteHeader(http.StatusNotFound)
+		case tag == "flaky" && n == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case tag == "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:"+tag)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(4), WithRetryPasses(2))
+	resultsChan := make(chan TagInfo, 10)
+
+	tags := []string{"ok", "flaky", "missing", "down"}
+	go client.FetchDigests(context.Background(), Repository{Registry: server.URL, Name: "repo"}, tags, resultsChan)
+
+	results := make(map[string]TagInfo)
+	for info := range resultsChan {
+		if _, dup := results[info.Tag]; dup {
+			t.Errorf("Tag %s reported more than once", info.Tag)
+		}
+		results[info.Tag] = info
+	}
+
+	if len(results) != len(tags) {
+		t.Fatalf("Expected %d results, got %d", len(tags), len(results))
+	}
+	if r := results["ok"]; r.Err != nil || r.Retried {
+		t.Errorf("ok: unexpected result %+v", r)
+	}
+	if r := results["flaky"]; r.Err != nil || !r.Retried || r.Digest != "sha256:flaky" {
+		t.Errorf("flaky: expected recovery on retry, got %+v", r)
+	}
+	if r := results["missing"]; r.Err == nil || r.Retried {
+		t.Errorf("missing: expected a non-retried failure, got %+v", r)
+	}
+	if r := results["down"]; r.Err == nil || !r.Retried {
+		t.Errorf("down: expected a failure after retries, got %+v", r)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["missing"] != 1 {
+		t.Errorf("Expected 404 not to be retried, got %d attempts", attempts["missing"])
+	}
+	if attempts["flaky"] != 2 {
+		t.Errorf("Expected flaky to be retried once, got %d attempts", attempts["flaky"])
+	}
+	if attempts["down"] != 3 {
+		t.Errorf("Expected down to be tried in the main pass and 2 retry passes, got %d attempts", attempts["down"])
+	}
+}
+
+// Test FetchDigests with retry passes disabled
+func TestFetchDigests_NoRetry(t *testing.T) {
+	calls := 0
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(2), WithRetryPasses(0))
+	resultsChan := make(chan TagInfo, 10)
+	go client.FetchDigests(context.Background(), Repository{Registry: server.URL, Name: "repo"}, []string{"a", "b"}, resultsChan)
+
+	failed := 0
+	for info := range resultsChan {
+		if info.Err != nil {
+			failed++
+		}
+	}
+	if failed != 2 || calls != 2 {
+		t.Errorf("Expected 2 failures from 2 calls, got %d failures from %d calls", failed, calls)
+	}
+}
+
+// Test FindTagsByDigest returns every matching tag, and reports tags that
+// could not be checked
+func TestFindTagsByDigest(t *testing.T) {
+	digests := map[string]string{
+		"v1":     "sha256:aaaa",
+		"v2":     "sha256:BBBB",
+		"latest": "sha256:bbbb",
+	}
+	tags := []string{"v1", "v2", "latest"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+			return
+		}
+		tag := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		digest, ok := digests[tag]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(2))
+	repo := Repository{Registry: server.URL, Name: "team/app"}
+
+	matches, err := client.FindTagsByDigest(context.Background(), repo, "sha256:bbbb")
+	slices.Sort(matches)
+	if err != nil || !slices.Equal(matches, []string{"latest", "v2"}) {
+		t.Errorf("FindTagsByDigest() = %v, %v, want [latest v2]", matches, err)
+	}
+
+	// A tag that disappears between listing and checking makes the result
+	// incomplete, but matches are still returned
+	tags = append(tags, "deleted")
+	matches, err = client.FindTagsByDigest(context.Background(), repo, "sha256:aaaa")
+	var incomplete *IncompleteError
+	if !errors.As(err, &incomplete) || len(incomplete.Failed) != 1 || incomplete.Total != 4 {
+		t.Fatalf("FindTagsByDigest() error = %v, want 1 of 4 tags failed", err)
+	}
+	if incomplete.Failed[0].Tag != "deleted" || !slices.Equal(matches, []string{"v1"}) {
+		t.Errorf("FindTagsByDigest() = %v, failed %v", matches, incomplete.Failed)
+	}
+}
+
+// Test FetchDigestsSeq checks the first page while later pages are pending
+func TestFetchDigestsSeq_Streaming(t *testing.T) {
+	checked := make(chan struct{})
+	var once sync.Once
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/repo/tags/list?n=2&last=b>; rel="next"`)
+				_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"a", "b"}})
+				return
+			}
+			// The second page is only served once a tag has been checked
+			select {
+			case <-checked:
+			case <-time.After(5 * time.Second):
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"c"}})
+			return
+		}
+		once.Do(func() { close(checked) })
+		w.Header().Set("Docker-Content-Digest", "sha256:"+r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(2))
+	repo := Repository{Registry: server.URL, Name: "repo"}
+
+	var listing Listing
+	resultsChan := make(chan TagInfo, 10)
+	go client.FetchDigestsSeq(context.Background(), repo, listing.Tags(client.Tags(context.Background(), repo)), resultsChan)
+
+	var got []string
+	for result := range resultsChan {
+		if result.Err != nil {
+			t.Errorf("tag %s: %v", result.Tag, result.Err)
+		}
+		got = append(got, result.Digest)
+	}
+	slices.Sort(got)
+
+	if listing.Err != nil || listing.Count != 3 {
+		t.Errorf("listing = %+v, want 3 tags and no error", listing)
+	}
+	if !slices.Equal(got, []string{"sha256:a", "sha256:b", "sha256:c"}) {
+		t.Errorf("digests = %v", got)
+	}
+}
+
+// Test FindTagsByDigest reports a listing that fails part way
+func TestFindTagsByDigest_ListingError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			if r.URL.Query().Get("last") != "" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Link", `</v2/repo/tags/list?n=1&last=v1>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"v1"}})
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	matches, err := client.FindTagsByDigest(context.Background(), Repository{Registry: server.URL, Name: "repo"}, "sha256:aaaa")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("FindTagsByDigest() error = %v, want the listing error", err)
+	}
+	if !slices.Equal(matches, []string{"v1"}) {
+		t.Errorf("FindTagsByDigest() = %v, want matches from the first page", matches)
+	}
+}
+
+// Test the observer sees every tag checked successfully, and only those
+func TestWithObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if tag == "broken" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:"+tag)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	observed := make(map[string]string)
+	repo := Repository{Registry: server.URL, Name: "repo"}
+	client := NewClient(WithConcurrency(2), WithObserver(func(r Repository, tag, digest string) {
+		if r != repo {
+			t.Errorf("observed repository %v, want %v", r, repo)
+		}
+		mu.Lock()
+		observed[tag] = digest
+		mu.Unlock()
+	}))
+
+	resultsChan := make(chan TagInfo, 10)
+	go client.FetchDigests(context.Background(), repo, []string{"aaaa", "bbbb", "broken"}, resultsChan)
+	for range resultsChan {
+	}
+
+	want := map[string]string{"aaaa": "sha256:aaaa", "bbbb": "sha256:bbbb"}
+	if !maps.Equal(observed, want) {
+		t.Errorf("observed = %v, want %v", observed, want)
+	}
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// StatusError is returned when the registry answers with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string { return e.Message }
+
+// AuthError is returned when no usable bearer token could be obtained. It
+// only means the credentials were refused if the cause does: a token
+// endpoint that is down or throttling is classified by its cause.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return "failed to get auth token: " + e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// IsAuthError reports whether err means the registry or its token endpoint
+// refused our credentials: a 401 or 403, or a token flow that cannot work,
+// such as a challenge without a realm. Token requests that failed with
+// another status or a network error are not auth errors.
+func IsAuthError(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden
+	}
+	var ae *AuthError
+	var netErr net.Error
+	return errors.As(err, &ae) && !errors.As(ae.Err, &netErr) && !errors.Is(ae.Err, context.Canceled)
+}
+
+// ErrorCategories lists the categories returned by ErrorCategory, in display order
+var ErrorCategories = []string{"auth", "not found", "throttled", "server error", "network", "other"}
+
+// ErrorCategory classifies a per-tag error for reporting
+func ErrorCategory(err error) string {
+	var se *StatusError
+	var netErr net.Error
+	switch {
+	case IsAuthError(err):
+		return "auth"
+	case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+		return "not found"
+	case errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests:
+		return "throttled"
+	case errors.As(err, &se) && se.StatusCode >= 500:
+		return "server error"
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// IsTransient reports whether a failed request might succeed if retried.
+// Missing tags and rejected credentials will not change on a second try.
+func IsTransient(err error) bool {
+	switch ErrorCategory(err) {
+	case "auth", "not found":
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,34 @@
+package registry
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Test ErrorCategory function
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&StatusError{StatusCode: http.StatusUnauthorized}, "auth"},
+		{&AuthError{Err: fmt.Errorf("no realm in auth header")}, "auth"},
+		{&AuthError{Err: &StatusError{StatusCode: http.StatusForbidden}}, "auth"},
+		{&AuthError{Err: &StatusError{StatusCode: http.StatusServiceUnavailable}}, "server error"},
+		{&AuthError{Err: &StatusError{StatusCode: http.StatusTooManyRequests}}, "throttled"},
+		{&AuthError{Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}, "network"},
+		{&StatusError{StatusCode: http.StatusNotFound}, "not found"},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, "throttled"},
+		{&StatusError{StatusCode: http.StatusBadGateway}, "server error"},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: fmt.Errorf("timeout")}, "network"},
+		{fmt.Errorf("no digest header for tag latest"), "other"},
+	}
+	for _, tt := range tests {
+		if got := ErrorCategory(tt.err); got != tt.want {
+			t.Errorf("ErrorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// requestLimiter bounds the number of in-flight requests made by a client.
+// Every successful acquire must be paired with a release reporting how the
+// request went, which adaptive limiters use to tune themselves.
+type requestLimiter interface {
+	acquire(ctx context.Context) error
+	release(latency time.Duration, err error)
+	limit() int
+}
+
+// fixedLimiter allows a constant number of requests in flight
+type fixedLimiter chan struct{}
+
+func newFixedLimiter(n int) fixedLimiter {
+	return make(fixedLimiter, n)
+}
+
+func (l fixedLimiter) acquire(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case l <- struct{}{}:
+		return nil
+	}
+}
+
+func (l fixedLimiter) release(time.Duration, error) { <-l }
+
+func (l fixedLimiter) limit() int { return cap(l) }
+
+const (
+	// aimdErrorBackoff scales the limit down when the registry throttles us
+	// or fails with a server error
+	aimdErrorBackoff = 0.5
+	// aimdLatencyBackoff scales the limit down when latency rises
+	aimdLatencyBackoff = 0.75
+	// aimdLatencyTolerance is how far smoothed latency may rise above the
+	// best recent latency before it counts as the registry slowing down
+	aimdLatencyTolerance = 2.0
+	// aimdLatencyWindow is the number of recent samples the best latency is
+	// taken from, so one lucky early response does not set the baseline for
+	// the rest of the scan
+	aimdLatencyWindow = 64
+	// aimdLatencyWeight is the weight of a new sample in the latency average
+	aimdLatencyWeight = 0.2
+)
+
+// aimdLimiter adapts the number of requests in flight to the registry's
+// health using additive increase, multiplicative decrease: every healthy
+// response grows the limit by 1/limit (about one more request per round of
+// responses), while a 429, a 5xx or rising latency shrinks it by a factor.
+// Decreases happen at most once per average round trip (before the first
+// success, once per window of requests in flight), so a burst of failures
+// from requests already in flight only counts once.
+type aimdLimiter struct {
+	mu           sync.Mutex
+	current      float64
+	min, max     float64
+	inFlight     int
+	wake         chan struct{}                    // closed and replaced when capacity frees up
+	latencies    [aimdLatencyWindow]time.Duration // ring of recent samples; zero is unused
+	nextLatency  int                              // index of the oldest sample in latencies
+	avgLatency   time.Duration                    // exponentially weighted moving average
+	lastDecrease time.Time
+	awaiting     int // responses still due from requests in flight at the last decrease
+}
+
+func newAIMDLimiter(initial, maxLimit int) *aimdLimiter {
+	return &aimdLimiter{
+		current: float64(min(max(initial, 1), maxLimit)),
+		min:     1,
+		max:     float64(maxLimit),
+		wake:    make(chan struct{}),
+	}
+}
+
+func (l *aimdLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.current) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (l *aimdLimiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	decreased := false
+	switch {
+	case err != nil:
+		// Only overload signals matter; a 404 or a cancelled request says
+		// nothing about how much load the registry can take
+		if category := ErrorCategory(err); category == "throttled" || category == "server error" {
+			decreased = l.decrease(aimdErrorBackoff)
+		}
+	default:
+		l.observe(latency)
+		if float64(l.avgLatency) > aimdLatencyTolerance*float64(l.minLatency()) {
+			decreased = l.decrease(aimdLatencyBackoff)
+		} else {
+			l.current = min(l.max, l.current+1/l.current)
+		}
+	}
+
+	// This response was one the last decrease was waiting for
+	if l.awaiting > 0 && !decreased {
+		l.awaiting--
+	}
+
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// observe records the latency of a successful request
+func (l *aimdLimiter) observe(latency time.Duration) {
+	l.latencies[l.nextLatency] = max(latency, 1)
+	l.nextLatency = (l.nextLatency + 1) % aimdLatencyWindow
+	if l.avgLatency == 0 {
+		l.avgLatency = latency
+		return
+	}
+	l.avgLatency = time.Duration(aimdLatencyWeight*float64(latency) + (1-aimdLatencyWeight)*float64(l.avgLatency))
+}
+
+// minLatency returns the best of the recent latency samples
+func (l *aimdLimiter) minLatency() time.Duration {
+	var best time.Duration
+	for _, latency := range l.latencies {
+		if latency != 0 && (best == 0 || latency < best) {
+			best = latency
+		}
+	}
+	return best
+}
+
+// decrease scales the limit down, at most once per average round trip, and
+// reports whether it did. Until a request has succeeded there is no round
+// trip time to go by, so it waits instead for the requests in flight at the
+// last decrease to answer.
+func (l *aimdLimiter) decrease(factor float64) bool {
+	now := time.Now()
+	if l.avgLatency == 0 && l.awaiting > 0 || now.Sub(l.lastDecrease) < l.avgLatency {
+		return false
+	}
+	l.current = max(l.min, l.current*factor)
+	l.lastDecrease = now
+	l.awaiting = l.inFlight
+	return true
+}
+
+func (l *aimdLimiter) limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.current)
+}
@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Test fixedLimiter blocks once its capacity is used
+func TestFixedLimiter(t *testing.T) {
+	l := newFixedLimiter(2)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(ctx); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire() on a full limiter = %v, want deadline exceeded", err)
+	}
+
+	l.release(0, nil)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Errorf("acquire() after release error = %v", err)
+	}
+	if l.limit() != 2 {
+		t.Errorf("limit() = %d, want 2", l.limit())
+	}
+}
+
+// Test aimdLimiter grows on healthy responses up to its maximum
+func TestAIMDLimiter_Growth(t *testing.T) {
+	l := newAIMDLimiter(1, 4)
+
+	for i := 0; i < 100; i++ {
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+		l.release(10*time.Millisecond, nil)
+	}
+
+	if l.limit() != 4 {
+		t.Errorf("limit() = %d, want 4", l.limit())
+	}
+}
+
+// Test aimdLimiter halves on throttling and server errors but ignores
+// errors that say nothing about load
+func TestAIMDLimiter_ErrorBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"throttled", &StatusError{StatusCode: 429, Message: "registry returned 429"}, 4},
+		{"server error", &StatusError{StatusCode: 503, Message: "registry returned 503"}, 4},
+		{"not found", &StatusError{StatusCode: 404, Message: "registry returned 404"}, 8},
+		{"cancelled", context.Canceled, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newAIMDLimiter(8, 8)
+			if err := l.acquire(context.Background()); err != nil {
+				t.Fatalf("acquire() error = %v", err)
+			}
+			l.release(0, tt.err)
+			if l.limit() != tt.want {
+				t.Errorf("limit() = %d, want %d", l.limit(), tt.want)
+			}
+		})
+	}
+}
+
+// Test a burst of 429s before any request succeeded only halves the limit
+// once per window of requests in flight, rather than once per response
+func TestAIMDLimiter_ErrorsBeforeSuccess(t *testing.T) {
+	l := newAIMDLimiter(16, 16)
+	throttled := &StatusError{StatusCode: 429, Message: "registry returned 429"}
+
+	l.inFlight = 16
+	for range 16 {
+		l.release(0, throttled)
+	}
+	if l.limit() != 8 {
+		t.Errorf("after the first window: limit() = %d, want 8", l.limit())
+	}
+
+	// Requests sent after the decrease can decrease it again
+	l.inFlight = 8
+	for range 8 {
+		l.release(0, throttled)
+	}
+	if l.limit() != 4 {
+		t.Errorf("after the second window: limit() = %d, want 4", l.limit())
+	}
+}
+
+// Test aimdLimiter backs off when latency rises well above the best recent one
+func TestAIMDLimiter_LatencyBackoff(t *testing.T) {
+	l := newAIMDLimiter(8, 8)
+
+	l.inFlight = 2
+	l.release(10*time.Millisecond, nil)
+	l.release(200*time.Millisecond, nil)
+
+	if l.limit() != 6 {
+		t.Errorf("limit() = %d, want 6", l.limit())
+	}
+}
+
+// Test the latency baseline follows the registry once the best sample is
+// out of the window, so a fast start does not throttle the rest of a scan
+func TestAIMDLimiter_LatencyWindow(t *testing.T) {
+	l := newAIMDLimiter(4, 64)
+
+	l.inFlight = 1
+	l.release(time.Millisecond, nil)
+	for range aimdLatencyWindow {
+		l.inFlight = 1
+		l.release(100*time.Millisecond, nil)
+	}
+	if got := l.minLatency(); got != 100*time.Millisecond {
+		t.Fatalf("minLatency() = %v, want 100ms", got)
+	}
+
+	// Steady latency at the new baseline grows the limit again
+	before := l.limit()
+	for range 32 {
+		l.inFlight = 1
+		l.release(100*time.Millisecond, nil)
+	}
+	if l.limit() <= before {
+		t.Errorf("limit() = %d, want more than %d", l.limit(), before)
+	}
+}
+
+// Test aimdLimiter only decreases once for a burst of failures from
+// requests that were already in flight
+func TestAIMDLimiter_Cooldown(t *testing.T) {
+	l := newAIMDLimiter(16, 16)
+	l.avgLatency = time.Hour
+
+	throttled := &StatusError{StatusCode: 429, Message: "registry returned 429"}
+	l.inFlight = 3
+	for i := 0; i < 3; i++ {
+		l.release(0, throttled)
+	}
+
+	if l.limit() != 8 {
+		t.Errorf("limit() = %d, want 8", l.limit())
+	}
+}
+
+// Test aimdLimiter wakes waiters when capacity frees up
+func TestAIMDLimiter_Wake(t *testing.T) {
+	l := newAIMDLimiter(1, 1)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(context.Background()) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire() succeeded while the limiter was full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.release(time.Millisecond, nil)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("acquire() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("acquire() was not woken by release")
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// manifestMediaTypes are the manifest types accepted when fetching digests
+var manifestMediaTypes = strings.Join([]string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}, ", ")
+
+// maxManifestSize bounds the manifest bodies read to compute sha512 digests
+const maxManifestSize = 4 << 20
+
+// manifestDigests are the digests of a manifest
+type manifestDigests struct {
+	digest string // as reported by the registry
+	sha512 string // computed from the content; only with WithSHA512
+}
+
+// ManifestDigest returns the digest of the manifest a tag points at, trying
+// the registry's mirrors first. The request counts against the registry's
+// concurrency limit.
+func (c *Client) ManifestDigest(ctx context.Context, repo Repository, tag string) (string, error) {
+	digests, err := c.manifestDigests(ctx, repo, tag)
+	return digests.digest, err
+}
+
+// manifestDigests is ManifestDigest, also computing the sha512 digest if the
+// client was created with WithSHA512
+func (c *Client) manifestDigests(ctx context.Context, repo Repository, tag string) (manifestDigests, error) {
+	var digests manifestDigests
+	err := c.withLimit(ctx, repo.Registry, func() error {
+		var err error
+		digests, err = c.fetchManifestDigest(ctx, repo, tag)
+		return err
+	})
+	return digests, err
+}
+
+// fetchManifestDigest fetches the digests for a specific tag, trying the
+// registry's mirrors first
+func (c *Client) fetchManifestDigest(ctx context.Context, repo Repository, tag string) (manifestDigests, error) {
+	var err error
+	for _, endpoint := range c.endpoints(repo.Registry) {
+		var digests manifestDigests
+		if digests, err = c.manifestDigest(ctx, endpoint, repo.Name, tag); err == nil {
+			return digests, nil
+		}
+		if endpoint != repo.Registry {
+			c.logger.Debug("mirror failed, trying next", "mirror", endpoint, "repository", repo.Name, "tag", tag, "error", err)
+		}
+	}
+	return manifestDigests{}, err
+}
+
+// manifestDigest fetches the digests for a tag from one registry endpoint
+func (c *Client) manifestDigest(ctx context.Context, registryURL, repository, tag string) (manifestDigests, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repository, tag)
+
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return manifestDigests{}, err
+	}
+
+	// Accept headers for different manifest types
+	req.Header.Set("Accept", manifestMediaTypes)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return manifestDigests{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return manifestDigests{}, &StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("registry returned %d for tag %s", resp.StatusCode, tag)}
+	}
+
+	// Digest is in the Docker-Content-Digest header
+	digests := manifestDigests{digest: resp.Header.Get("Docker-Content-Digest")}
+	if digests.digest == "" {
+		return manifestDigests{}, fmt.Errorf("no digest header for tag %s", tag)
+	}
+
+	// Registries only report sha256, so a sha512 digest has to be computed
+	// from the manifest itself
+	if c.sha512 {
+		h := sha512.New()
+		n, err := io.Copy(h, io.LimitReader(resp.Body, maxManifestSize+1))
+		if err != nil {
+			return manifestDigests{}, fmt.Errorf("reading manifest for tag %s: %w", tag, err)
+		}
+		if n > maxManifestSize {
+			return manifestDigests{}, fmt.Errorf("manifest for tag %s is larger than %d bytes", tag, maxManifestSize)
+		}
+		digests.sha512 = "sha512:" + hex.EncodeToString(h.Sum(nil))
+	}
+
+	return digests, nil
+}
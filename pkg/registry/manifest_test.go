@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test ManifestDigest function
+func TestManifestDigest(t *testing.T) {
+	expectedDigest := "sha256:abcd1234"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Verify Accept headers
+		acceptHeader := r.Header.Get("Accept")
+		if !strings.Contains(acceptHeader, "application/vnd.docker.distribution.manifest.v2+json") {
+			t.Errorf("Missing expected Accept header")
+		}
+
+		w.Header().Set("Docker-Content-Digest", expectedDigest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1))
+	digest, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "repo"}, "latest")
+	if err != nil {
+		t.Fatalf("ManifestDigest() error = %v", err)
+	}
+	if digest != expectedDigest {
+		t.Errorf("ManifestDigest() = %v, want %v", digest, expectedDigest)
+	}
+}
+
+// Test ManifestDigest with 401 authentication retry
+func TestManifestDigest_AuthRetry(t *testing.T) {
+	// Setup token server
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"token": "manifest-token",
+		})
+	}))
+	defer tokenServer.Close()
+
+	expectedDigest := "sha256:manifestdigest123"
+
+	// Setup registry server that requires auth
+	callCount := 0
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		// First call: return 401
+		if callCount == 1 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry",scope="repository:test:pull"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Second call: verify token and return manifest digest
+		authHeader := r.Header.Get("Authorization")
+		if authHeader != "Bearer manifest-token" {
+			t.Errorf("Expected Bearer manifest-token, got %s", authHeader)
+		}
+
+		// Verify Accept headers
+		acceptHeader := r.Header.Get("Accept")
+		if !strings.Contains(acceptHeader, "application/vnd.docker.distribution.manifest.v2+json") {
+			t.Errorf("Missing expected Accept header")
+		}
+
+		w.Header().Set("Docker-Content-Digest", expectedDigest)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registryServer.Close()
+
+	client := NewClient(WithConcurrency(1))
+	digest, err := client.ManifestDigest(context.Background(), Repository{Registry: registryServer.URL, Name: "test"}, "v1.0")
+	if err != nil {
+		t.Fatalf("ManifestDigest() error = %v", err)
+	}
+
+	if digest != expectedDigest {
+		t.Errorf("ManifestDigest() = %v, want %v", digest, expectedDigest)
+	}
+	if callCount != 2 {
+		t.Errorf("Expected 2 calls (401 + retry), got %d", callCount)
+	}
+}
+
+// Test requests go to a registry's mirrors first and fall back to the
+// registry itself
+func TestManifestDigest_Mirrors(t *testing.T) {
+	var mirrorCalls, upstreamCalls int
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorCalls++
+		switch r.URL.Path {
+		case "/v2/team/app/manifests/cached":
+			w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+			w.WriteHeader(http.StatusOK)
+		case "/v2/team/app/tags/list":
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"cached"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		w.Header().Set("Docker-Content-Digest", "sha256:bbbb")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	client := NewClient(WithMirrors(strings.TrimPrefix(upstream.URL, "http://"), mirror.URL))
+
+	if digest, err := client.ManifestDigest(context.Background(), Repository{Registry: upstream.URL, Name: "team/app"}, "cached"); err != nil || digest != "sha256:aaaa" {
+		t.Errorf("ManifestDigest(cached) = %q, %v, want the mirror's digest", digest, err)
+	}
+	if digest, err := client.ManifestDigest(context.Background(), Repository{Registry: upstream.URL, Name: "team/app"}, "fresh"); err != nil || digest != "sha256:bbbb" {
+		t.Errorf("ManifestDigest(fresh) = %q, %v, want the registry's digest", digest, err)
+	}
+	if tags, err := client.ListTags(context.Background(), Repository{Registry: upstream.URL, Name: "team/app"}); err != nil || !slices.Equal(tags, []string{"cached"}) {
+		t.Errorf("ListTags() = %v, %v", tags, err)
+	}
+	if mirrorCalls != 3 || upstreamCalls != 1 {
+		t.Errorf("expected 3 mirror and 1 registry requests, got %d and %d", mirrorCalls, upstreamCalls)
+	}
+}
+
+// Test WithSHA512 reports the sha512 digest of the manifest content, also
+// when the manifest comes from cache
+func TestFetchDigests_SHA512(t *testing.T) {
+	body := `{"schemaVersion":2}`
+	sum := sha512.Sum512([]byte(body))
+	want := "sha512:" + hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:abcd1234")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	repo := Repository{Registry: server.URL, Name: "repo"}
+
+	check := func(client *Client, want string) {
+		t.Helper()
+		results := make(chan TagInfo, 1)
+		client.FetchDigests(context.Background(), repo, []string{"latest"}, results)
+		if got := <-results; got.Err != nil || got.Digest != "sha256:abcd1234" || got.SHA512 != want {
+			t.Errorf("FetchDigests() = %+v, want sha512 %q", got, want)
+		}
+	}
+	check(NewClient(), "")
+	check(NewClient(WithSHA512()), want)
+
+	cache := NewCache(time.Hour)
+	check(NewClient(WithSHA512(), WithCache(cache)), want)
+	check(NewClient(WithSHA512(), WithCache(cache)), want)
+}
@@ -0,0 +1,278 @@
+package registry
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a RoundTripper with extra behaviour, e.g. metrics, audit
+// headers or request signing. The client's own auth, retry, rate limiting
+// and caching are middlewares too, and can be composed with Chain.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Chain wraps rt in middlewares. The first middleware is the outermost, so
+// it sees each request first and each response last.
+func Chain(rt http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+// maxRetryAfter caps how long Retry waits for a Retry-After header
+const maxRetryAfter = 30 * time.Second
+
+// Retry returns middleware that retries GET and HEAD requests up to attempts
+// more times after a network error, a 429 or a 502, 503 or 504, waiting
+// backoff, doubling each time, or as long as a Retry-After header asks.
+func Retry(attempts int, backoff time.Duration) Middleware {
+	return retry(attempts, backoff, discardLogger)
+}
+
+// retry is Retry, logging each retry and how long it waits to logger
+func retry(attempts int, backoff time.Duration, logger *slog.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &retryTransport{next: next, attempts: attempts, backoff: backoff, logger: logger}
+	}
+}
+
+// retryTransport is the RoundTripper built by Retry
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	backoff  time.Duration
+	logger   *slog.Logger
+}
+
+// retryAttemptKey is the request context key holding the number of a
+// retried attempt, so the request log can tell retries apart
+type retryAttemptKey struct{}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	wait := t.backoff
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.WithContext(context.WithValue(ctx, retryAttemptKey{}, attempt))
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt >= t.attempts || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+
+		delay := wait
+		attrs := []any{"method", req.Method, "url", req.URL.Redacted(), "attempt", attempt + 1}
+		if resp != nil {
+			if after := retryAfter(resp.Header.Get("Retry-After")); after > 0 {
+				delay = after
+				attrs = append(attrs, "retry_after", after)
+			}
+			attrs = append(attrs, "status", resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		} else {
+			attrs = append(attrs, "error", err)
+		}
+		wait *= 2
+		t.logger.Debug("retrying http request", append(attrs, "backoff", delay)...)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a request that got resp or err is worth retrying
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date,
+// returning 0 if it is missing or invalid
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(header); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		d = time.Until(t)
+	}
+	return min(max(d, 0), maxRetryAfter)
+}
+
+// DefaultCacheSize is the number of responses a cache made by NewCache holds
+const DefaultCacheSize = 10000
+
+// Cache holds manifest responses so that looking up a tag again is cheap.
+// Once full, the least recently used response makes way for a new one. It
+// is safe for concurrent use and can be shared by several clients.
+type Cache struct {
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element // manifest URL -> element holding a *cacheEntry
+	lru     *list.List               // most recently used first
+}
+
+// cacheEntry is a cached manifest response. The body is kept too, since
+// clients computing sha512 digests hash it.
+type cacheEntry struct {
+	key    string
+	header http.Header
+	body   []byte
+	stored time.Time
+}
+
+// NewCache creates a cache of DefaultCacheSize responses, which are used
+// without asking the registry for ttl after they were stored or last
+// revalidated
+func NewCache(ttl time.Duration) *Cache {
+	return NewCacheSize(ttl, DefaultCacheSize)
+}
+
+// NewCacheSize is NewCache holding at most size responses
+func NewCacheSize(ttl time.Duration, size int) *Cache {
+	return &Cache{ttl: ttl, size: max(size, 1), entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Len returns the number of cached responses
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(e)
+	return *e.Value.(*cacheEntry), true
+}
+
+func (c *Cache) put(key string, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, header: header, body: body, stored: time.Now()}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Caching returns middleware that answers manifest GET requests from cache.
+// Entries younger than the cache's TTL are served without a request; older
+// ones are revalidated with If-None-Match, which registries answer with a
+// bodyless 304 while the tag still points at the same manifest.
+func Caching(cache *Cache) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &cacheTransport{next: next, cache: cache}
+	}
+}
+
+// cacheTransport is the RoundTripper built by Caching
+type cacheTransport struct {
+	next  http.RoundTripper
+	cache *Cache
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || !strings.Contains(req.URL.Path, "/manifests/") {
+		return t.next.RoundTrip(req)
+	}
+	key := req.URL.String()
+
+	entry, ok := t.cache.get(key)
+	if ok && time.Since(entry.stored) < t.cache.ttl {
+		return cachedResponse(req, entry), nil
+	}
+
+	etag := ""
+	if ok {
+		etag = entry.header.Get("ETag")
+	}
+	if etag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		_ = resp.Body.Close()
+		t.cache.put(key, entry.header, entry.body)
+		return cachedResponse(req, entry), nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") != "":
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		if len(body) > maxManifestSize {
+			// Too large to cache; hand on the rest of the body unread
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+		t.cache.put(key, resp.Header.Clone(), body)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
+
+// cachedResponse builds a 200 response to req from a cache entry
+func cachedResponse(req *http.Request, entry cacheEntry) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(entry.body)),
+		ContentLength: int64(len(entry.body)),
+		Request:       req,
+	}
+}
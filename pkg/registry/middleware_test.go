@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// Test Chain applies the first middleware outermost
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+
+	get(t, Chain(base, tag("a"), tag("b")), "http://registry.test/v2/")
+	if want := []string{"a", "b", "base"}; !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+}
+
+// Test Retry retries transient statuses for idempotent requests only
+func TestRetry(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch {
+		case r.URL.Path == "/flaky" && n == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/flaky":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	rt := Chain(http.DefaultTransport, Retry(2, time.Millisecond))
+
+	if code := get(t, rt, server.URL+"/flaky"); code != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("flaky: status %d after %d calls, want 200 after 2", code, calls.Load())
+	}
+
+	calls.Store(0)
+	if code := get(t, rt, server.URL+"/down"); code != http.StatusServiceUnavailable || calls.Load() != 3 {
+		t.Errorf("down: status %d after %d calls, want 503 after 3", code, calls.Load())
+	}
+
+	calls.Store(0)
+	req, _ := http.NewRequestWithContext(context.Background(), "POST", server.URL+"/down", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("POST was sent %d times, want 1", calls.Load())
+	}
+}
+
+// Test retryAfter function
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"2", 2 * time.Second},
+		{"3600", maxRetryAfter},
+		{"soon", 0},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// Test Caching serves fresh entries locally and revalidates stale ones
+func TestCaching(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		digest   = "sha256:aaaa"
+		requests []string // If-None-Match of each manifest request
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Header.Get("If-None-Match"))
+		etag := `"` + digest + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Docker-Content-Digest", digest)
+	}))
+	defer server.Close()
+
+	repo := Repository{Registry: server.URL, Name: "repo"}
+	check := func(client *Client, want string) {
+		t.Helper()
+		got, err := client.ManifestDigest(context.Background(), repo, "latest")
+		if err != nil || got != want {
+			t.Errorf("ManifestDigest() = %q, %v, want %q", got, err, want)
+		}
+	}
+
+	sent := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+
+	// A fresh entry is shared between clients without a request
+	cache := NewCache(time.Hour)
+	check(NewClient(WithCache(cache)), "sha256:aaaa")
+	check(NewClient(WithCache(cache)), "sha256:aaaa")
+	if got := sent(); len(got) != 1 || cache.Len() != 1 {
+		t.Fatalf("requests = %q, cache entries = %d, want 1 request and 1 entry", got, cache.Len())
+	}
+
+	// A stale entry is revalidated, then replaced once the tag moves
+	client := NewClient(WithCache(NewCache(0)))
+	check(client, "sha256:aaaa")
+	check(client, "sha256:aaaa")
+	mu.Lock()
+	digest = "sha256:bbbb"
+	mu.Unlock()
+	check(client, "sha256:bbbb")
+	want := []string{"", "", `"sha256:aaaa"`, `"sha256:aaaa"`}
+	if got := sent(); !slices.Equal(got, want) {
+		t.Errorf("If-None-Match headers = %q, want %q", got, want)
+	}
+}
+
+// Test a full cache evicts the least recently used response
+func TestCache_Eviction(t *testing.T) {
+	cache := NewCacheSize(time.Hour, 2)
+	cache.put("a", http.Header{}, nil)
+	cache.put("b", http.Header{}, nil)
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("get(a) missed")
+	}
+	cache.put("c", http.Header{}, nil)
+
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.get(key); ok != want {
+			t.Errorf("get(%s) cached = %v, want %v", key, ok, want)
+		}
+	}
+
+	// Replacing an entry does not grow the cache
+	cache.put("c", http.Header{"Etag": {`"new"`}}, nil)
+	if e, _ := cache.get("c"); cache.Len() != 2 || e.header.Get("ETag") != `"new"` {
+		t.Errorf("after replacing c: Len() = %d, entry %+v", cache.Len(), e)
+	}
+}
+
+// Test WithMiddleware middlewares see authenticated requests and token requests
+func TestWithMiddleware(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Audit") != "ci" {
+			t.Errorf("%s missing audit header", r.URL.Path)
+		}
+		switch {
+		case r.URL.Path == "/token":
+			_, _ = w.Write([]byte(`{"token":"t"}`))
+		case r.Header.Get("Authorization") != "Bearer t":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		}
+	}))
+	defer server.Close()
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	audit := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			seen = append(seen, req.URL.Path+" "+strings.Fields(req.Header.Get("Authorization") + " -")[0])
+			mu.Unlock()
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Audit", "ci")
+			return next.RoundTrip(req)
+		})
+	}
+
+	client := NewClient(WithMiddleware(audit))
+	if _, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "repo"}, "v1"); err != nil {
+		t.Fatalf("ManifestDigest() error = %v", err)
+	}
+	want := []string{"/v2/repo/manifests/v1 -", "/token -", "/v2/repo/manifests/v1 Bearer"}
+	if !slices.Equal(seen, want) {
+		t.Errorf("middleware saw %q, want %q", seen, want)
+	}
+}
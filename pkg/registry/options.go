@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures a Client
+type Option func(*config)
+
+// config collects the options a Client is built from
+type config struct {
+	concurrency    int
+	adaptive       bool
+	retryPasses    int
+	retryWorkers   int // 0 means half of concurrency
+	rates          RateLimits
+	hosts          map[string]*hostConfig
+	proxy          *url.URL
+	connectTimeout time.Duration
+	requestTimeout time.Duration
+	logger         *slog.Logger
+	userAgent      string
+	transport      http.RoundTripper
+	wrappers       []func(http.RoundTripper) http.RoundTripper
+	middlewares    []Middleware
+	retries        int // request-level retries by the Retry middleware
+	retryBackoff   time.Duration
+	cache          *Cache
+	observer       Observer
+	sha512         bool
+}
+
+// hostConfig holds the settings for a single registry host
+type hostConfig struct {
+	concurrency int
+	username    string
+	password    string
+	rootCAs     *x509.CertPool
+	mirrors     []string // base URLs tried in order before the registry itself
+}
+
+// credentials returns the username and password configured for a host
+func (c *config) credentials(host string) (username, password string) {
+	if hc, ok := c.hosts[host]; ok {
+		return hc.username, hc.password
+	}
+	return "", ""
+}
+
+// host returns the settings for a registry, creating them on first use
+func (c *config) host(name string) *hostConfig {
+	host := CanonicalHost(name)
+	if c.hosts[host] == nil {
+		c.hosts[host] = &hostConfig{}
+	}
+	return c.hosts[host]
+}
+
+// WithConcurrency sets the maximum number of requests in flight, shared by
+// every registry without its own limit. The default is DefaultConcurrency.
+func WithConcurrency(n int) Option {
+	return func(c *config) { c.concurrency = max(1, n) }
+}
+
+// WithAdaptiveConcurrency adapts the number of requests in flight to the
+// registry's health, backing off on 429s, 5xx responses and rising latency.
+// The configured concurrency becomes the maximum.
+func WithAdaptiveConcurrency() Option {
+	return func(c *config) { c.adaptive = true }
+}
+
+// WithHostConcurrency gives a registry its own limit on requests in flight
+// instead of sharing the client's. Registries are named as in image
+// references, e.g. docker.io or registry.internal:5000.
+func WithHostConcurrency(registry string, n int) Option {
+	return func(c *config) { c.host(registry).concurrency = max(1, n) }
+}
+
+// WithRetryPasses sets how many extra passes FetchDigests makes over tags
+// that failed with a transient error. The default is 1; 0 disables retries.
+func WithRetryPasses(n int) Option {
+	return func(c *config) { c.retryPasses = max(0, n) }
+}
+
+// WithRetryWorkers sets the concurrency of retry passes. The default is half
+// of the client's concurrency.
+func WithRetryWorkers(n int) Option {
+	return func(c *config) { c.retryWorkers = max(1, n) }
+}
+
+// WithRateLimit limits the request rate to each registry host. rate.Inf
+// means no limit, which is the default.
+func WithRateLimit(limit rate.Limit) Option {
+	return func(c *config) { c.rates.Default = limit }
+}
+
+// WithHostRateLimit limits the request rate to one registry host, replacing
+// the rate set with WithRateLimit for it
+func WithHostRateLimit(registry string, limit rate.Limit) Option {
+	return func(c *config) {
+		if c.rates.Hosts == nil {
+			c.rates.Hosts = make(map[string]rate.Limit)
+		}
+		c.rates.Hosts[CanonicalHost(registry)] = limit
+	}
+}
+
+// WithRateLimits applies the default and per-host rates of limits, as
+// WithRateLimit and WithHostRateLimit do
+func WithRateLimits(limits RateLimits) Option {
+	return func(c *config) {
+		if limits.Default != 0 {
+			WithRateLimit(limits.Default)(c)
+		}
+		for host, limit := range limits.Hosts {
+			WithHostRateLimit(host, limit)(c)
+		}
+	}
+}
+
+// WithCredentials sets the username and password sent when requesting bearer
+// tokens for a registry. Without credentials tokens are requested anonymously.
+func WithCredentials(registry, username, password string) Option {
+	return func(c *config) {
+		h := c.host(registry)
+		h.username, h.password = username, password
+	}
+}
+
+// WithRootCAs sets the certificates trusted for a registry, e.g. one with a
+// private CA
+func WithRootCAs(registry string, pool *x509.CertPool) Option {
+	return func(c *config) { c.host(registry).rootCAs = pool }
+}
+
+// WithMirrors sets pull-through mirrors for a registry, tried in order
+// before the registry itself. Mirrors without a scheme use https.
+func WithMirrors(registry string, mirrors ...string) Option {
+	return func(c *config) {
+		h := c.host(registry)
+		h.mirrors = nil
+		for _, mirror := range mirrors {
+			if !strings.Contains(mirror, "://") {
+				mirror = "https://" + mirror
+			}
+			h.mirrors = append(h.mirrors, strings.TrimSuffix(mirror, "/"))
+		}
+	}
+}
+
+// WithProxy sends requests through proxy, except for hosts listed in
+// NO_PROXY. A nil proxy, the default, uses the proxy environment variables.
+func WithProxy(proxy *url.URL) Option {
+	return func(c *config) { c.proxy = proxy }
+}
+
+// WithConnectTimeout bounds the TCP connect and TLS handshake. The default
+// is DefaultConnectTimeout.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(c *config) { c.connectTimeout = d }
+}
+
+// WithRequestTimeout bounds a single HTTP request, including reading the
+// body. Waiting for a rate limit (see WithRateLimit) does not count against
+// it, and each retry of a request gets a fresh timeout. The default is
+// DefaultRequestTimeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *config) { c.requestTimeout = d }
+}
+
+// WithLogger logs progress, and every HTTP exchange at debug level, to logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) { c.logger = logger }
+}
+
+// WithUserAgent sets the User-Agent header sent with every request. The
+// default is DefaultUserAgent.
+func WithUserAgent(userAgent string) Option {
+	return func(c *config) { c.userAgent = userAgent }
+}
+
+// WithTransport sends requests through rt instead of the network. The
+// proxy, root CA and connect timeout options have no effect with it.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *config) { c.transport = rt }
+}
+
+// WithTransportWrapper wraps the transport that talks to the network, e.g.
+// to record traffic. Wrappers are applied in the order given, beneath the
+// client's own logging and rate limiting.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *config) { c.wrappers = append(c.wrappers, wrap) }
+}
+
+// WithMiddleware adds middlewares to the client's transport chain, in the
+// order given. They run inside the cache and auth middlewares and outside
+// request retries, rate limiting and logging, so they see each request once,
+// with its Authorization header, and token requests as well.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *config) { c.middlewares = append(c.middlewares, middlewares...) }
+}
+
+// WithRequestRetries retries each request up to attempts more times after a
+// network error, 429 or 502-504 response, starting with a wait of backoff.
+// These retries happen inside a tag's check, before the retry passes of
+// FetchDigests; the default is none.
+func WithRequestRetries(attempts int, backoff time.Duration) Option {
+	return func(c *config) { c.retries, c.retryBackoff = max(0, attempts), backoff }
+}
+
+// Observer is told about every tag whose digest FetchDigests fetched
+type Observer func(repo Repository, tag, digest string)
+
+// WithObserver calls observer for every tag checked successfully by
+// FetchDigests and the functions built on it, e.g. to keep a history of
+// where tags pointed. It is called from the worker goroutines, so it must be
+// safe for concurrent use, and should be quick.
+func WithObserver(observer Observer) Option {
+	return func(c *config) { c.observer = observer }
+}
+
+// WithSHA512 also computes the sha512 digest of every manifest FetchDigests
+// checks, from the manifest's content, and reports it in TagInfo.SHA512.
+// Registries only report sha256 digests, so this is the only way to match a
+// sha512 one; it costs reading each manifest body.
+func WithSHA512() Option {
+	return func(c *config) { c.sha512 = true }
+}
+
+// WithCache answers manifest requests from cache, which may be shared with
+// other clients. Without it every check goes to the registry.
+func WithCache(cache *Cache) Option {
+	return func(c *config) { c.cache = cache }
+}
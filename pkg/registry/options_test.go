@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+)
+
+// Test per-registry options are keyed by the host requests go to
+func TestHostOptions(t *testing.T) {
+	cfg := &config{hosts: make(map[string]*hostConfig)}
+	for _, opt := range []Option{
+		WithCredentials("docker.io", "robot", "secret"),
+		WithHostConcurrency("registry-1.docker.io", 4),
+		WithMirrors("docker.io", "mirror.gcr.io", "http://localhost:5000/"),
+		WithHostConcurrency("registry.internal:5000", 0),
+	} {
+		opt(cfg)
+	}
+
+	hub := cfg.hosts["registry-1.docker.io"]
+	if hub == nil || hub.username != "robot" || hub.concurrency != 4 {
+		t.Fatalf("docker.io settings = %+v", hub)
+	}
+	if !slices.Equal(hub.mirrors, []string{"https://mirror.gcr.io", "http://localhost:5000"}) {
+		t.Errorf("docker.io mirrors = %v", hub.mirrors)
+	}
+	if internal := cfg.hosts["registry.internal:5000"]; internal == nil || internal.concurrency != 1 {
+		t.Errorf("registry.internal:5000 settings = %+v, want concurrency raised to 1", internal)
+	}
+}
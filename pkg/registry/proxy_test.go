@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Test proxyFunc honours NO_PROXY for an explicit proxy
+func TestProxyFunc(t *testing.T) {
+	t.Setenv("NO_PROXY", "registry.internal,.corp.example")
+
+	proxy, err := url.Parse("http://proxy.internal:3128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectProxy := proxyFunc(proxy)
+
+	tests := map[string]bool{
+		"https://ghcr.io/v2/":              true,
+		"https://auth.docker.io/token":     true,
+		"https://registry.internal/v2/":    false,
+		"https://harbor.corp.example/v2/":  false,
+		"http://registry.internal:5000/v2": false,
+	}
+	for target, wantProxy := range tests {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+		got, err := selectProxy(req)
+		if err != nil {
+			t.Fatalf("proxy(%s) error = %v", target, err)
+		}
+		if (got != nil) != wantProxy {
+			t.Errorf("proxy(%s) = %v, want proxied %v", target, got, wantProxy)
+		}
+	}
+}
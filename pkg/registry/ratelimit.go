@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateUnits maps the unit suffixes accepted by ParseRate to their duration
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseRate parses a request rate such as "20/s", "600/m" or a bare "20"
+// (per second). Zero means unlimited, and is returned as rate.Inf.
+func ParseRate(s string) (rate.Limit, error) {
+	count, unit, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		unit = "s"
+	}
+	per, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: unit must be s, m or h", s)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid rate %q: want a number of requests such as 20/s", s)
+	}
+	if n == 0 {
+		return rate.Inf, nil
+	}
+	return rate.Limit(n / per.Seconds()), nil
+}
+
+// RateLimits holds the request rate allowed for each registry host, plus a
+// default for hosts without their own entry. A zero value allows everything.
+type RateLimits struct {
+	Default rate.Limit            // 0 means no limit
+	Hosts   map[string]rate.Limit // keyed by CanonicalHost; rate.Inf means no limit
+}
+
+// forHost returns the rate limit for a host, or rate.Inf if it has none
+func (rl RateLimits) forHost(host string) rate.Limit {
+	if limit, ok := rl.Hosts[host]; ok {
+		return limit
+	}
+	if rl.Default == 0 {
+		return rate.Inf
+	}
+	return rl.Default
+}
+
+// enabled reports whether any host is rate limited
+func (rl RateLimits) enabled() bool {
+	if rl.Default != 0 && rl.Default != rate.Inf {
+		return true
+	}
+	for _, limit := range rl.Hosts {
+		if limit != rate.Inf {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit returns middleware that limits the request rate to each host to
+// limit, or to the rate hosts gives for it. Hosts are named as in image
+// references, e.g. docker.io; rate.Inf or 0 means no limit.
+func RateLimit(limit rate.Limit, hosts map[string]rate.Limit) Middleware {
+	limits := RateLimits{Default: limit, Hosts: make(map[string]rate.Limit)}
+	for host, hostLimit := range hosts {
+		limits.Hosts[CanonicalHost(host)] = hostLimit
+	}
+	return limits.middleware
+}
+
+// middleware wraps next in a rateLimitTransport enforcing rl
+func (rl RateLimits) middleware(next http.RoundTripper) http.RoundTripper {
+	return newRateLimitTransport(next, rl)
+}
+
+// rateLimitTransport delays requests so that each host receives at most its
+// configured rate. Every request made through the client counts, including
+// token requests, which go to the host named in the auth realm.
+type rateLimitTransport struct {
+	base     http.RoundTripper
+	limits   RateLimits
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newRateLimitTransport(base http.RoundTripper, limits RateLimits) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:     base,
+		limits:   limits,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// limiter returns the token bucket for a host, creating it on first use.
+// The bucket holds one second's worth of requests, so a burst is never
+// larger than what the host is allowed in a second.
+func (t *rateLimitTransport) limiter(host string) *rate.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.limiters[host]; ok {
+		return l
+	}
+	limit := t.limits.forHost(host)
+	burst := 1
+	if limit != rate.Inf {
+		burst = max(1, int(math.Ceil(float64(limit))))
+	}
+	l := rate.NewLimiter(limit, burst)
+	t.limiters[host] = l
+	return l
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter(req.URL.Host).Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
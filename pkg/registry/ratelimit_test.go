@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Test ParseRate function
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    rate.Limit
+		wantErr bool
+	}{
+		{"20/s", 20, false},
+		{"20", 20, false},
+		{"120/m", 2, false},
+		{"3600/h", 1, false},
+		{"0.5/s", 0.5, false},
+		{"0", rate.Inf, false},
+		{"20/d", 0, true},
+		{"fast", 0, true},
+		{"-1/s", 0, true},
+		{"NaN", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// Test RateLimits falls back to the default rate for hosts without their own
+func TestRateLimits(t *testing.T) {
+	limits := RateLimits{
+		Default: 10,
+		Hosts:   map[string]rate.Limit{"registry.internal": 2, "ghcr.io": rate.Inf},
+	}
+	tests := map[string]rate.Limit{
+		"registry.internal": 2,
+		"ghcr.io":           rate.Inf,
+		"docker.io":         10,
+	}
+	for host, want := range tests {
+		if got := limits.forHost(host); got != want {
+			t.Errorf("forHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !limits.enabled() {
+		t.Error("enabled() = false, want true")
+	}
+	if (RateLimits{}).enabled() || (RateLimits{Hosts: map[string]rate.Limit{"a": rate.Inf}}).enabled() {
+		t.Error("enabled() = true for limits that allow everything")
+	}
+}
+
+// Test the client spaces out requests, token requests included, to the
+// configured rate
+func TestRateLimitTransport(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/token":
+			_, _ = w.Write([]byte(`{"token":"test-token"}`))
+		case r.Header.Get("Authorization") != "Bearer test-token":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:test/repo:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(4), WithHostRateLimit(strings.TrimPrefix(server.URL, "http://"), 50))
+
+	repo := Repository{Registry: server.URL, Name: "test/repo"}
+	start := time.Now()
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		if _, err := client.ManifestDigest(context.Background(), repo, tag); err != nil {
+			t.Fatalf("ManifestDigest(%s) error = %v", tag, err)
+		}
+	}
+
+	// 401, token, retried manifest and two more manifests all go through the
+	// rate limited transport
+	if len(times) != 5 {
+		t.Fatalf("expected 5 requests, got %d", len(times))
+	}
+
+	tr := client.httpClient.Transport.(*authTransport).next.(*rateLimitTransport)
+	l := tr.limiter(strings.TrimPrefix(server.URL, "http://"))
+	if l.Limit() != 50 || l.Burst() != 50 {
+		t.Errorf("limiter = %v/s burst %d, want 50/s burst 50", l.Limit(), l.Burst())
+	}
+
+	// Empty the bucket, then 10 more requests need ~200ms
+	l.AllowN(time.Now(), int(l.Tokens()))
+	for i := 0; i < 10; i++ {
+		if _, err := client.ManifestDigest(context.Background(), repo, "v1"); err != nil {
+			t.Fatalf("ManifestDigest() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("15 requests at 50/s took %v, expected rate limiting", elapsed)
+	}
+}
+
+// Test waiting for the rate limit does not count against the request timeout
+func TestRateLimitTransport_RequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// A burst of 4, then one request every 250ms: the last tags wait far
+	// longer than the timeout for their turn
+	client := NewClient(WithConcurrency(5), WithRateLimit(4), WithRequestTimeout(100*time.Millisecond))
+	repo := Repository{Registry: server.URL, Name: "test/repo"}
+	tags := []string{"v1", "v2", "v3", "v4", "v5", "v6", "v7"}
+
+	var wg sync.WaitGroup
+	for _, tag := range tags {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.ManifestDigest(context.Background(), repo, tag); err != nil {
+				t.Errorf("ManifestDigest(%s) error = %v", tag, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
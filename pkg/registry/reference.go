@@ -0,0 +1,66 @@
+package registry
+
+import "strings"
+
+// Repository identifies a repository on a registry
+type Repository struct {
+	Registry string // base URL of the registry API, e.g. https://ghcr.io
+	Name     string // repository path, e.g. library/nginx
+}
+
+// ParseRepository parses an image reference without tag or digest, such as
+// nginx, ghcr.io/owner/repo or localhost:5000/image. Docker Hub names are
+// expanded the way docker does it.
+func ParseRepository(image string) Repository {
+	parts := strings.SplitN(image, "/", 2)
+
+	if len(parts) == 1 {
+		// No registry specified, default to docker.io
+		return Repository{Registry: "https://registry-1.docker.io", Name: "library/" + parts[0]}
+	}
+
+	registry := parts[0]
+	repo := parts[1]
+
+	switch registry {
+	case "docker.io":
+		// Special handling for Docker Hub
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+		return Repository{Registry: "https://registry-1.docker.io", Name: repo}
+	case "ghcr.io":
+		return Repository{Registry: "https://ghcr.io", Name: repo}
+	case "quay.io":
+		return Repository{Registry: "https://quay.io", Name: repo}
+	default:
+		// Generic registry
+		return Repository{Registry: "https://" + registry, Name: repo}
+	}
+}
+
+// Host returns the host[:port] requests for the repository go to
+func (r Repository) Host() string {
+	return urlHost(r.Registry)
+}
+
+// String returns the repository as host/name
+func (r Repository) String() string {
+	return r.Host() + "/" + r.Name
+}
+
+// urlHost returns the host[:port] part of a base URL such as https://ghcr.io
+func urlHost(baseURL string) string {
+	_, host, found := strings.Cut(baseURL, "://")
+	if !found {
+		host = baseURL
+	}
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
+
+// CanonicalHost returns the host requests for a registry name go to, so
+// docker.io and registry-1.docker.io name the same registry
+func CanonicalHost(name string) string {
+	return ParseRepository(name + "/repository").Host()
+}
@@ -0,0 +1,68 @@
+package registry
+
+import "testing"
+
+// Test ParseRepository function
+func TestParseRepository(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantURL  string
+		wantRepo string
+	}{
+		{
+			name:     "simple image (no registry)",
+			input:    "nginx",
+			wantURL:  "https://registry-1.docker.io",
+			wantRepo: "library/nginx",
+		},
+		{
+			name:     "docker.io with single name",
+			input:    "docker.io/nginx",
+			wantURL:  "https://registry-1.docker.io",
+			wantRepo: "library/nginx",
+		},
+		{
+			name:     "docker.io with org/repo",
+			input:    "docker.io/myorg/myrepo",
+			wantURL:  "https://registry-1.docker.io",
+			wantRepo: "myorg/myrepo",
+		},
+		{
+			name:     "ghcr.io registry",
+			input:    "ghcr.io/owner/repo",
+			wantURL:  "https://ghcr.io",
+			wantRepo: "owner/repo",
+		},
+		{
+			name:     "quay.io registry",
+			input:    "quay.io/org/repo",
+			wantURL:  "https://quay.io",
+			wantRepo: "org/repo",
+		},
+		{
+			name:     "custom registry",
+			input:    "registry.example.com/project/image",
+			wantURL:  "https://registry.example.com",
+			wantRepo: "project/image",
+		},
+		{
+			name:     "custom registry with port",
+			input:    "localhost:5000/myimage",
+			wantURL:  "https://localhost:5000",
+			wantRepo: "myimage",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseRepository(tt.input)
+			if got.Registry != tt.wantURL {
+				t.Errorf("ParseRepository() Registry = %v, want %v", got.Registry, tt.wantURL)
+			}
+			if got.Name != tt.wantRepo {
+				t.Errorf("ParseRepository() Name = %v, want %v", got.Name, tt.wantRepo)
+			}
+		})
+	}
+}
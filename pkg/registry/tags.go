@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"strings"
+)
+
+// ListTags lists every tag in a repository, following pagination and
+// trying the registry's mirrors first. Each page counts against the
+// registry's concurrency limit.
+func (c *Client) ListTags(ctx context.Context, repo Repository) ([]string, error) {
+	var err error
+	for _, endpoint := range c.endpoints(repo.Registry) {
+		var tags []string
+		collect := func(tag string, _ error) bool {
+			tags = append(tags, tag)
+			return true
+		}
+		if _, err = c.listTags(ctx, repo, endpoint, collect); err == nil {
+			c.logger.Info("listed tags", "registry", endpoint, "repository", repo.Name, "tags", len(tags))
+			return tags, nil
+		}
+		c.logger.Debug("tag listing failed", "registry", endpoint, "repository", repo.Name, "error", err)
+	}
+	return nil, err
+}
+
+// Tags returns an iterator over the tags of a repository that fetches each
+// page only when the previous one has been consumed, so callers can start on
+// the first tags of a large repository straight away. A failure ends the
+// iteration with a single non-nil error. Mirrors are tried first, but only
+// until one of them has produced a page; after that its errors are final.
+func (c *Client) Tags(ctx context.Context, repo Repository) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		var err error
+		for _, endpoint := range c.endpoints(repo.Registry) {
+			var n int
+			n, err = c.listTags(ctx, repo, endpoint, yield)
+			if err == nil {
+				c.logger.Info("listed tags", "registry", endpoint, "repository", repo.Name, "tags", n)
+				return
+			}
+			if errors.Is(err, errStopped) {
+				return
+			}
+			c.logger.Debug("tag listing failed", "registry", endpoint, "repository", repo.Name, "error", err)
+			if n > 0 {
+				break
+			}
+		}
+		yield("", err)
+	}
+}
+
+// errStopped reports that the consumer of a tag iterator stopped early
+var errStopped = errors.New("iteration stopped")
+
+// listTags passes the tags from one of the repository's endpoints to yield a
+// page at a time, returning how many were yielded
+func (c *Client) listTags(ctx context.Context, repo Repository, endpoint string, yield func(string, error) bool) (int, error) {
+	var n int
+	url := fmt.Sprintf("%s/v2/%s/tags/list?n=1000", endpoint, repo.Name)
+
+	for url != "" {
+		var tags []string
+		err := c.withLimit(ctx, repo.Registry, func() error {
+			var err error
+			tags, url, err = c.fetchTagsPage(ctx, url)
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		for _, tag := range tags {
+			n++
+			if !yield(tag, nil) {
+				return n, errStopped
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// parseLinkHeader parses the Link header to extract the next page URL
+func parseLinkHeader(linkHeader string) string {
+	// Link header format: </v2/repo/tags/list?n=100&last=tag99>; rel="next"
+	parts := strings.Split(linkHeader, ";")
+	if len(parts) < 2 {
+		return ""
+	}
+
+	// Extract URL from angle brackets
+	urlPart := strings.TrimSpace(parts[0])
+	if !strings.HasPrefix(urlPart, "<") || !strings.HasSuffix(urlPart, ">") {
+		return ""
+	}
+
+	// Check if this is a "next" link
+	for _, part := range parts[1:] {
+		if strings.Contains(part, `rel="next"`) {
+			return strings.Trim(urlPart, "<>")
+		}
+	}
+
+	return ""
+}
+
+// fetchTagsPage fetches a single page of tags and returns the next URL if available
+func (c *Client) fetchTagsPage(ctx context.Context, url string) ([]string, string, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("registry returned %d", resp.StatusCode)}
+	}
+
+	var result struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+
+	// Parse Link header for next page
+	linkHeader := resp.Header.Get("Link")
+	nextURL := ""
+	if linkHeader != "" {
+		nextPath := parseLinkHeader(linkHeader)
+		if nextPath != "" {
+			// nextPath is relative, need to construct full URL
+			nextURL = registryBase(url) + nextPath
+		}
+	}
+
+	return result.Tags, nextURL, nil
+}
@@ -0,0 +1,339 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// createTestTags generates a slice of test tag names
+func createTestTags(count int) []string {
+	tags := make([]string, count)
+	for i := 0; i < count; i++ {
+		tags[i] = fmt.Sprintf("tag%d", i)
+	}
+	return tags
+}
+
+// Test parseLinkHeader function
+func TestParseLinkHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "valid next link",
+			input: `</v2/repo/tags/list?n=100&last=tag99>; rel="next"`,
+			want:  "/v2/repo/tags/list?n=100&last=tag99",
+		},
+		{
+			name:  "no next rel",
+			input: `</v2/repo/tags/list?n=100&last=tag99>; rel="prev"`,
+			want:  "",
+		},
+		{
+			name:  "malformed - no brackets",
+			input: `/v2/repo/tags/list; rel="next"`,
+			want:  "",
+		},
+		{
+			name:  "empty header",
+			input: "",
+			want:  "",
+		},
+		{
+			name:  "no semicolon",
+			input: `</v2/repo/tags/list>`,
+			want:  "",
+		},
+		{
+			name:  "single link without next rel",
+			input: `</v2/repo/tags/list?page=2>; rel="prev"`,
+			want:  "",
+		},
+		{
+			name:  "malformed brackets",
+			input: `<incomplete; rel="next"`,
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLinkHeader(tt.input)
+			if got != tt.want {
+				t.Errorf("parseLinkHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test fetchTagsPage function
+func TestFetchTagsPage(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupServer func() *httptest.Server
+		wantTags    []string
+		wantNextURL string
+		wantErr     bool
+	}{
+		{
+			name: "successful fetch without pagination",
+			setupServer: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					_ = json.NewEncoder(w).Encode(map[string][]string{
+						"tags": {"latest", "v1.0", "v2.0"},
+					})
+				}))
+			},
+			wantTags:    []string{"latest", "v1.0", "v2.0"},
+			wantNextURL: "",
+			wantErr:     false,
+		},
+		{
+			name: "fetch with Link header for next page",
+			setupServer: func() *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Link", `</v2/repo/tags/list?n=100&last=v2.0>; rel="next"`)
+					_ = json.NewEncoder(w).Encode(map[string][]string{
+						"tags": {"latest", "v1.0", "v2.0"},
+					})
+				}))
+			},
+			wantTags:    []string{"latest", "v1.0", "v2.0"},
+			wantNextURL: "/v2/repo/tags/list?n=100&last=v2.0",
+			wantErr:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := tt.setupServer()
+			defer server.Close()
+
+			client := NewClient(WithConcurrency(1))
+			tags, nextURL, err := client.fetchTagsPage(context.Background(), server.URL+"/v2/repo/tags/list")
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchTagsPage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(tags) != len(tt.wantTags) {
+				t.Errorf("fetchTagsPage() got %d tags, want %d", len(tags), len(tt.wantTags))
+			}
+			for i, tag := range tags {
+				if tag != tt.wantTags[i] {
+					t.Errorf("fetchTagsPage() tag[%d] = %v, want %v", i, tag, tt.wantTags[i])
+				}
+			}
+
+			// Verify next URL path matches (ignoring server base URL)
+			if tt.wantNextURL != "" {
+				if !strings.Contains(nextURL, tt.wantNextURL) {
+					t.Errorf("fetchTagsPage() nextURL = %v, should contain %v", nextURL, tt.wantNextURL)
+				}
+			} else if nextURL != "" {
+				t.Errorf("fetchTagsPage() nextURL = %v, want empty", nextURL)
+			}
+		})
+	}
+}
+
+// Test fetchTagsPage with 401 authentication retry
+func TestFetchTagsPage_AuthRetry(t *testing.T) {
+	// Setup token server
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"token": "auth-token-xyz",
+		})
+	}))
+	defer tokenServer.Close()
+
+	// Setup registry server that requires auth
+	callCount := 0
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount++
+
+		// First call: return 401
+		if callCount == 1 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry",scope="repository:test:pull"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Second call: verify token and return tags
+		authHeader := r.Header.Get("Authorization")
+		if authHeader != "Bearer auth-token-xyz" {
+			t.Errorf("Expected Bearer auth-token-xyz, got %s", authHeader)
+		}
+		_ = json.NewEncoder(w).Encode(map[string][]string{
+			"tags": {"tag1", "tag2"},
+		})
+	}))
+	defer registryServer.Close()
+
+	client := NewClient(WithConcurrency(1))
+	tags, _, err := client.fetchTagsPage(context.Background(), registryServer.URL+"/v2/test/tags/list")
+	if err != nil {
+		t.Fatalf("fetchTagsPage() error = %v", err)
+	}
+
+	if len(tags) != 2 {
+		t.Errorf("Expected 2 tags, got %d", len(tags))
+	}
+	if callCount != 2 {
+		t.Errorf("Expected 2 calls (401 + retry), got %d", callCount)
+	}
+}
+
+// Test ListTags with pagination
+func TestListTags(t *testing.T) {
+	callCount := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		callCount++
+
+		switch callCount {
+		case 1:
+			// Use relative path like real Docker Registry API
+			w.Header().Set("Link", `</v2/repo/tags/list?n=100&last=tag100>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{
+				"tags": createTestTags(100),
+			})
+		case 2:
+			w.Header().Set("Link", `</v2/repo/tags/list?n=100&last=tag200>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{
+				"tags": createTestTags(100),
+			})
+		case 3:
+			// Last page - no Link header
+			_ = json.NewEncoder(w).Encode(map[string][]string{
+				"tags": createTestTags(50),
+			})
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1))
+	tags, err := client.ListTags(context.Background(), Repository{Registry: server.URL, Name: "repo"})
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+
+	if len(tags) != 250 {
+		t.Errorf("ListTags() returned %d tags, want 250", len(tags))
+	}
+	if callCount != 3 {
+		t.Errorf("Expected 3 HTTP calls for pagination, got %d", callCount)
+	}
+}
+
+// Test ListTags reports auth failures as such
+func TestListTags_AuthFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1))
+	_, err := client.ListTags(context.Background(), Repository{Registry: server.URL, Name: "repo"})
+	if err == nil {
+		t.Fatal("ListTags() expected error")
+	}
+	if !IsAuthError(err) {
+		t.Errorf("IsAuthError(%v) = false, want true", err)
+	}
+	if IsAuthError(&StatusError{StatusCode: http.StatusNotFound, Message: "registry returned 404"}) {
+		t.Error("IsAuthError(404) = true, want false")
+	}
+}
+
+// Test Tags yields each page before fetching the next and stops early
+func TestTags(t *testing.T) {
+	var pages atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("last") {
+		case "":
+			pages.Add(1)
+			w.Header().Set("Link", `</v2/repo/tags/list?n=2&last=b>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"a", "b"}})
+		case "b":
+			pages.Add(1)
+			w.Header().Set("Link", `</v2/repo/tags/list?n=2&last=d>; rel="next"`)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"c", "d"}})
+		default:
+			pages.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithConcurrency(1))
+	repo := Repository{Registry: server.URL, Name: "repo"}
+
+	// Stopping after the first page never requests the second
+	for tag, err := range client.Tags(context.Background(), repo) {
+		if err != nil {
+			t.Fatalf("Tags() error = %v", err)
+		}
+		if pages.Load() != 1 {
+			t.Errorf("tag %s yielded after %d pages, want 1", tag, pages.Load())
+		}
+		if tag == "b" {
+			break
+		}
+	}
+	if pages.Load() != 1 {
+		t.Errorf("fetched %d pages after break, want 1", pages.Load())
+	}
+
+	// A failing page ends the iteration with its error
+	var (
+		tags []string
+		errs []error
+	)
+	for tag, err := range client.Tags(context.Background(), repo) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if strings.Join(tags, ",") != "a,b,c,d" {
+		t.Errorf("tags = %v, want [a b c d]", tags)
+	}
+	if len(errs) != 1 || ErrorCategory(errs[0]) != "server error" {
+		t.Errorf("errors = %v, want one server error", errs)
+	}
+}
+
+// Test Tags falls back from a mirror only before it has yielded any tags
+func TestTags_MirrorFallback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]string{"tags": {"upstream"}})
+	}))
+	defer upstream.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+
+	client := NewClient(WithMirrors(upstream.URL, mirror.URL))
+	var tags []string
+	for tag, err := range client.Tags(context.Background(), Repository{Registry: upstream.URL, Name: "repo"}) {
+		if err != nil {
+			t.Fatalf("Tags() error = %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) != 1 || tags[0] != "upstream" {
+		t.Errorf("tags = %v, want [upstream]", tags)
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// discardLogger is used when logging is off
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// redactAuth keeps only the scheme of an Authorization header, so logs
+// show how a request was authenticated without leaking the credentials
+func redactAuth(header string) string {
+	if header == "" {
+		return ""
+	}
+	scheme, _, _ := strings.Cut(header, " ")
+	return scheme + " [REDACTED]"
+}
+
+// logTransport logs every HTTP exchange at debug level
+type logTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	attrs := []any{
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"latency", time.Since(start),
+	}
+	if attempt, ok := req.Context().Value(retryAttemptKey{}).(int); ok {
+		attrs = append(attrs, "attempt", attempt)
+	}
+	if auth := redactAuth(req.Header.Get("Authorization")); auth != "" {
+		attrs = append(attrs, "auth", auth)
+	}
+	if err != nil {
+		t.logger.Debug("http request failed", append(attrs, "error", err)...)
+		return resp, err
+	}
+	t.logger.Debug("http request", append(attrs, "status", resp.StatusCode)...)
+	return resp, nil
+}
+
+// timeoutTransport bounds each request sent through base, including reading
+// the response body. It sits below the rate limiter, so time spent waiting
+// for a rate token does not count against the timeout.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases a request's timeout once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// hostTransport sends requests for some hosts through their own transport,
+// e.g. one trusting a private CA, and everything else through base
+type hostTransport struct {
+	base  http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt, ok := t.hosts[req.URL.Host]; ok {
+		return rt.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withRootCAs returns a copy of transport that trusts pool
+func withRootCAs(transport *http.Transport, pool *x509.CertPool) *http.Transport {
+	t := transport.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.RootCAs = pool
+	return t
+}
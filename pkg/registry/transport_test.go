@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test redactAuth function
+func TestRedactAuth(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"Bearer abc.def.ghi": "Bearer [REDACTED]",
+		"Basic dXNlcjpwYXNz": "Basic [REDACTED]",
+	}
+	for input, want := range tests {
+		if got := redactAuth(input); got != want {
+			t.Errorf("redactAuth(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Test requests and tag results are logged without leaking credentials
+func TestClientLogging(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+	)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+		case r.Header.Get("Authorization") == "":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			mu.Lock()
+			attempts[r.URL.Path]++
+			first := attempts[r.URL.Path] == 1
+			mu.Unlock()
+			// The flaky tag fails once and succeeds on the retry pass
+			if strings.HasSuffix(r.URL.Path, "/flaky") && first {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	var logs syncBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewClient(WithConcurrency(1), WithLogger(logger))
+
+	resultsChan := make(chan TagInfo, 2)
+	client.FetchDigests(context.Background(), Repository{Registry: server.URL, Name: "test/repo"}, []string{"stable", "flaky"}, resultsChan)
+
+	out := logs.String()
+	for _, want := range []string{
+		"method=GET",
+		"status=401",
+		"status=503",
+		`auth="Bearer [REDACTED]"`,
+		"latency=",
+		"url=" + server.URL + "/v2/test/repo/manifests/stable",
+		`msg="tag failed, will retry" repository=test/repo tag=flaky pass=0`,
+		`msg="checked tag" repository=test/repo tag=flaky pass=1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logs missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret-token") {
+		t.Errorf("logs leak the bearer token:\n%s", out)
+	}
+}
+
+// Test request-level retries are logged with their attempt number and wait
+func TestLogTransport_Retries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:aaaa")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var logs syncBuffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewClient(WithLogger(logger), WithRequestRetries(2, time.Millisecond))
+	if _, err := client.ManifestDigest(context.Background(), Repository{Registry: server.URL, Name: "repo"}, "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := logs.String()
+	for _, want := range []string{
+		`msg="retrying http request" method=GET url=` + server.URL + `/v2/repo/manifests/latest attempt=1 status=503 backoff=1ms`,
+		"attempt=1 status=200",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logs missing %q:\n%s", want, out)
+		}
+	}
+}
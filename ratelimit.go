@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// rateFlag is a repeatable -rate flag: "20/s" sets the default rate, and
+// "registry.example.com=20/s" sets the rate for one registry host
+type rateFlag struct {
+	limits *registry.RateLimits
+}
+
+func (f rateFlag) String() string {
+	if f.limits == nil {
+		return ""
+	}
+	var parts []string
+	if f.limits.Default != 0 {
+		parts = append(parts, formatRate(f.limits.Default))
+	}
+	for _, host := range slices.Sorted(maps.Keys(f.limits.Hosts)) {
+		parts = append(parts, host+"="+formatRate(f.limits.Hosts[host]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set accepts a comma separated list, as produced by String, so the whole
+// flag can be given in one environment variable
+func (f rateFlag) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		if err := f.set(strings.TrimSpace(part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f rateFlag) set(s string) error {
+	host, value, found := strings.Cut(s, "=")
+	if !found {
+		value = host
+	}
+	limit, err := registry.ParseRate(value)
+	if err != nil {
+		return err
+	}
+	if !found {
+		f.limits.Default = limit
+		return nil
+	}
+	if host == "" {
+		return fmt.Errorf("invalid rate %q: missing host before =", s)
+	}
+	if f.limits.Hosts == nil {
+		f.limits.Hosts = make(map[string]rate.Limit)
+	}
+	f.limits.Hosts[registry.CanonicalHost(host)] = limit
+	return nil
+}
+
+// formatRate renders a rate limit in requests per second
+func formatRate(limit rate.Limit) string {
+	if limit == rate.Inf {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(limit), 'f', -1, 64) + "/s"
+}
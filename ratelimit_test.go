@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// Test rateFlag sets the default and per-host rates
+func TestRateFlag(t *testing.T) {
+	var limits registry.RateLimits
+	f := rateFlag{&limits}
+
+	for _, value := range []string{"10/s", "registry.internal=2/s", "ghcr.io=0"} {
+		if err := f.Set(value); err != nil {
+			t.Fatalf("Set(%q) error = %v", value, err)
+		}
+	}
+	if err := f.Set("=5/s"); err == nil {
+		t.Error("Set(\"=5/s\") expected error")
+	}
+
+	if limits.Default != 10 || limits.Hosts["registry.internal"] != 2 || limits.Hosts["ghcr.io"] != rate.Inf {
+		t.Errorf("limits = %+v", limits)
+	}
+	if got := f.String(); got != "10/s,ghcr.io=0,registry.internal=2/s" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// Test -rate host names are normalised like image references
+func TestRateFlag_RegistryHost(t *testing.T) {
+	var limits registry.RateLimits
+	if err := (rateFlag{&limits}).Set("docker.io=5/s"); err != nil {
+		t.Fatal(err)
+	}
+	if limits.Hosts["registry-1.docker.io"] != rate.Limit(5) {
+		t.Errorf("docker.io rate not applied to registry-1.docker.io: %+v", limits)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// maxBatchLookups bounds the number of lookups in one batch request
+const maxBatchLookups = 1000
+
+// lookupServer answers digest lookups over HTTP. Every lookup shares one
+// registry client, and so its token and manifest caches and its limits.
+// Concurrent lookups of the same repository share a single scan.
+type lookupServer struct {
+	client      *registry.Client
+	minPrefix   int
+	scanTimeout time.Duration // 0 means no limit
+
+	mu    sync.Mutex
+	scans map[registry.Repository]*scanCall // scans in flight
+}
+
+// scanCall is a scan in flight, which lookups of its repository wait for
+type scanCall struct {
+	done chan struct{}
+	inv  inventory
+}
+
+func newLookupServer(client *registry.Client, minPrefix int, scanTimeout time.Duration) *lookupServer {
+	return &lookupServer{
+		client:      client,
+		minPrefix:   minPrefix,
+		scanTimeout: scanTimeout,
+		scans:       make(map[registry.Repository]*scanCall),
+	}
+}
+
+// lookupRequest is one lookup in a batch request
+type lookupRequest struct {
+	Image   string   `json:"image"`
+	Digest  string   `json:"digest,omitempty"`
+	Digests []string `json:"digests,omitempty"`
+}
+
+// failedTag is a tag that could not be checked
+type failedTag struct {
+	Tag   string `json:"tag"`
+	Error string `json:"error"`
+}
+
+// lookupResult is the answer to one lookup
+type lookupResult struct {
+	Image    string              `json:"image"`
+	Matches  map[string][]string `json:"matches"` // target digest -> matching tags, sorted
+	Checked  int                 `json:"checked"` // tags whose digest was fetched
+	Failed   []failedTag         `json:"failed,omitempty"`
+	Complete bool                `json:"complete"` // every tag was checked, so missing matches are definite
+	Error    string              `json:"error,omitempty"`
+
+	err error // the error behind Error, for choosing a status code
+}
+
+// handler returns the server's HTTP API
+func (s *lookupServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/lookup", s.handleLookup)
+	mux.HandleFunc("POST /v1/lookup", s.handleBatch)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSONResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return mux
+}
+
+// handleLookup answers GET /v1/lookup?image=...&digest=...; digest may be
+// repeated
+func (s *lookupServer) handleLookup(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := lookupRequest{Image: query.Get("image"), Digests: query["digest"]}
+	image, digests, err := s.parseLookup(req)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result := s.lookup(r.Context(), image, digests)
+	status := http.StatusOK
+	switch {
+	case result.err == nil:
+	case registry.ErrorCategory(result.err) == "not found":
+		status = http.StatusNotFound
+	default:
+		status = http.StatusBadGateway
+	}
+	writeJSONResponse(w, status, result)
+}
+
+// handleBatch answers POST /v1/lookup with a JSON body of the form
+// {"lookups": [{"image": "...", "digest": "..."}, ...]}. Lookups run
+// concurrently and the results come back in the same order.
+func (s *lookupServer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Lookups []lookupRequest `json:"lookups"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if len(body.Lookups) == 0 || len(body.Lookups) > maxBatchLookups {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("between 1 and %d lookups are required", maxBatchLookups)})
+		return
+	}
+
+	// Validate everything before scanning anything
+	images := make([]string, len(body.Lookups))
+	digests := make([][]string, len(body.Lookups))
+	for i, req := range body.Lookups {
+		var err error
+		if images[i], digests[i], err = s.parseLookup(req); err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("lookup %d: %v", i, err)})
+			return
+		}
+	}
+
+	results := make([]lookupResult, len(body.Lookups))
+	var wg sync.WaitGroup
+	for i := range body.Lookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = s.lookup(r.Context(), images[i], digests[i])
+		}()
+	}
+	wg.Wait()
+
+	writeJSONResponse(w, http.StatusOK, map[string]any{"results": results})
+}
+
+// parseLookup validates a lookup, returning its image and target digests
+func (s *lookupServer) parseLookup(req lookupRequest) (string, []string, error) {
+	image := strings.TrimPrefix(req.Image, "docker://")
+	if image == "" {
+		return "", nil, errors.New("image is required")
+	}
+	raw := req.Digests
+	if req.Digest != "" {
+		raw = append([]string{req.Digest}, raw...)
+	}
+	if len(raw) == 0 {
+		return "", nil, errors.New("digest is required")
+	}
+
+	var digests []string
+	for _, d := range raw {
+		digest, err := parseDigest(d, s.minPrefix)
+		if err != nil {
+			return "", nil, err
+		}
+		if needsSHA512([]string{digest}) {
+			// Inventories are shared between requests and only hold the
+			// sha256 digests registries report
+			return "", nil, fmt.Errorf("sha512 digest %q is not supported by the server", d)
+		}
+		digests = append(digests, digest)
+	}
+	return image, digests, nil
+}
+
+// lookup finds the tags of image matching digests
+func (s *lookupServer) lookup(ctx context.Context, image string, digests []string) lookupResult {
+	result := lookupResult{Image: image, Matches: make(map[string][]string)}
+
+	inv, err := s.inventory(ctx, registry.ParseRepository(image))
+	if err != nil {
+		result.err, result.Error = err, err.Error()
+		return result
+	}
+
+	for target, tags := range inv.matches(newDigestMatcher(digests)) {
+		result.Matches[target] = tags
+	}
+	result.Checked = len(inv.digests)
+	for _, f := range inv.failed {
+		result.Failed = append(result.Failed, failedTag{Tag: f.Tag, Error: f.Err.Error()})
+	}
+	result.Complete = inv.complete()
+	if inv.err != nil {
+		result.err, result.Error = inv.err, inv.err.Error()
+	}
+	return result
+}
+
+// inventory scans a repository, or waits for the scan already in flight for
+// it. The scan is not tied to ctx, since other lookups may be waiting on it;
+// only this caller stops waiting when ctx ends.
+func (s *lookupServer) inventory(ctx context.Context, repo registry.Repository) (inventory, error) {
+	s.mu.Lock()
+	call, ok := s.scans[repo]
+	if !ok {
+		call = &scanCall{done: make(chan struct{})}
+		s.scans[repo] = call
+		go s.scan(repo, call)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.inv, nil
+	case <-ctx.Done():
+		return inventory{}, ctx.Err()
+	}
+}
+
+// scan runs a coalesced scan and hands the result to everyone waiting on it
+func (s *lookupServer) scan(repo registry.Repository, call *scanCall) {
+	ctx := context.Background()
+	if s.scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scanTimeout)
+		defer cancel()
+	}
+
+	call.inv = scanInventory(ctx, s.client, repo)
+
+	s.mu.Lock()
+	delete(s.scans, repo)
+	s.mu.Unlock()
+	close(call.done)
+}
+
+// writeJSONResponse writes v as a JSON response with the given status
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// runServe runs the serve subcommand: an HTTP server answering lookups
+// until interrupted
+func runServe(args []string, opts clientOptions, minPrefix int) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:8080", "listen on `address`")
+	cacheTTL := fs.Duration("cache-ttl", time.Minute, "serve cached manifest digests for this long before revalidating them with the registry")
+	if err := fs.Parse(args); err != nil {
+		return exitFatal
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Error: unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitFatal
+	}
+
+	opts.cache = registry.NewCache(*cacheTTL)
+	s := newLookupServer(opts.newClient(), minPrefix, opts.scanTimeout)
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+	server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	// Save what the scans saw as the server runs, not only when it stops
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := opts.history.flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: saving history: %v\n", err)
+			}
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on http://%s\n", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFatal
+	}
+	return exitMatch
+}
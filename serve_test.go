@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"oci-tag-finder/internal/registrytest"
+)
+
+// getLookup sends a GET lookup to server and decodes the result. It is safe
+// to call from other goroutines: failures are reported with t.Error.
+func getLookup(t *testing.T, server *httptest.Server, query url.Values) (int, lookupResult) {
+	t.Helper()
+	var result lookupResult
+	req, err := http.NewRequestWithContext(context.Background(), "GET", server.URL+"/v1/lookup?"+query.Encode(), nil)
+	if err != nil {
+		t.Error(err)
+		return 0, result
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, result
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Error(err)
+	}
+	return resp.StatusCode, result
+}
+
+// Test GET /v1/lookup against a fake registry
+func TestLookupServer_Get(t *testing.T) {
+	fake := registrytest.New(registrytest.WithAuth("", ""))
+	defer fake.Close()
+	digest := fake.PushImage("app", "v1")
+	fake.Tag("app", "latest", digest)
+	fake.PushImage("app", "v2")
+
+	s := newLookupServer(fakeRegistryOptions(fake, "", "").newClient(), defaultMinPrefix, 0)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		status   int
+		matches  []string
+		complete bool
+	}{
+		{"match", url.Values{"image": {fake.Ref("app")}, "digest": {digest}}, http.StatusOK, []string{"latest", "v1"}, true},
+		{"prefix", url.Values{"image": {fake.Ref("app")}, "digest": {digest[:19]}}, http.StatusOK, []string{"latest", "v1"}, true},
+		{"no match", url.Values{"image": {fake.Ref("app")}, "digest": {"sha256:" + strings.Repeat("0", 64)}}, http.StatusOK, nil, true},
+		{"missing image", url.Values{"digest": {digest}}, http.StatusBadRequest, nil, false},
+		{"bad digest", url.Values{"image": {fake.Ref("app")}, "digest": {"sha256:xyz"}}, http.StatusBadRequest, nil, false},
+		{"sha512 digest", url.Values{"image": {fake.Ref("app")}, "digest": {"sha512:" + strings.Repeat("0", 128)}}, http.StatusBadRequest, nil, false},
+		{"unknown repository", url.Values{"image": {fake.Ref("missing")}, "digest": {digest}}, http.StatusNotFound, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, result := getLookup(t, server, tt.query)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d (%+v)", status, tt.status, result)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			var matches []string
+			for _, tags := range result.Matches {
+				matches = append(matches, tags...)
+			}
+			if !slices.Equal(matches, tt.matches) || result.Complete != tt.complete || result.Checked != 3 {
+				t.Errorf("result = %+v, want matches %v", result, tt.matches)
+			}
+		})
+	}
+}
+
+// Test POST /v1/lookup answers each lookup in order
+func TestLookupServer_Batch(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	app := fake.PushImage("app", "v1")
+	web := fake.PushImage("web", "stable")
+
+	s := newLookupServer(fakeRegistryOptions(fake, "", "").newClient(), defaultMinPrefix, 0)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	body := `{"lookups": [{"image": "` + fake.Ref("web") + `", "digest": "` + web + `"}, {"image": "` + fake.Ref("app") + `", "digests": ["` + app + `"]}]}`
+	req, _ := http.NewRequestWithContext(context.Background(), "POST", server.URL+"/v1/lookup", strings.NewReader(body))
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var got struct{ Results []lookupResult }
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(got.Results) != 2 {
+		t.Fatalf("status %d, results %+v", resp.StatusCode, got.Results)
+	}
+	if !slices.Equal(got.Results[0].Matches[web], []string{"stable"}) || !slices.Equal(got.Results[1].Matches[app], []string{"v1"}) {
+		t.Errorf("results = %+v", got.Results)
+	}
+
+	req, _ = http.NewRequestWithContext(context.Background(), "POST", server.URL+"/v1/lookup", strings.NewReader(`{"lookups": []}`))
+	resp, err = server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty batch status = %d, want 400", resp.StatusCode)
+	}
+}
+
+// Test concurrent lookups of one repository share a scan
+func TestLookupServer_Coalescing(t *testing.T) {
+	fake := registrytest.New()
+	defer fake.Close()
+	digest := fake.PushImage("app", "v1")
+	fake.PushImage("app", "v2")
+	// Hold the listing so that every lookup arrives while it is in flight
+	fake.Inject(registrytest.Fault{Path: "/tags/list", Delay: 200 * time.Millisecond, Times: 1})
+
+	s := newLookupServer(fakeRegistryOptions(fake, "", "").newClient(), defaultMinPrefix, 0)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, result := getLookup(t, server, url.Values{"image": {fake.Ref("app")}, "digest": {digest}})
+			if status != http.StatusOK || !slices.Equal(result.Matches[digest], []string{"v1"}) {
+				t.Errorf("status %d, result %+v", status, result)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := fake.Count("/tags/list"); n != 1 {
+		t.Errorf("tag list requests = %d, want 1 shared scan", n)
+	}
+	if n := fake.Count("/manifests/"); n != 2 {
+		t.Errorf("manifest requests = %d, want 2", n)
+	}
+}
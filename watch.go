@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"oci-tag-finder/pkg/registry"
+)
+
+// watcher re-scans a repository and works out how the tags matching its
+// targets changed since the previous scan
+type watcher struct {
+	client  *registry.Client
+	image   string
+	repo    registry.Repository
+	targets []string
+	matches map[string][]string // target -> sorted matching tags; nil before the first scan
+}
+
+func newWatcher(client *registry.Client, image string, targets []string) *watcher {
+	return &watcher{
+		client:  client,
+		image:   image,
+		repo:    registry.ParseRepository(image),
+		targets: targets,
+	}
+}
+
+// scan runs one scan and returns the changes since the previous one; the
+// first scan reports every match as added. Tags that could not be checked
+// keep their previous state, and a scan whose listing failed changes
+// nothing and returns the error.
+func (w *watcher) scan(ctx context.Context) ([]matchEvent, inventory, error) {
+	inv := scanInventory(ctx, w.client, w.repo)
+	if inv.err != nil {
+		return nil, inv, inv.err
+	}
+
+	unchecked := make(map[string]bool)
+	for _, f := range inv.failed {
+		unchecked[f.Tag] = true
+	}
+
+	now := time.Now()
+	current := inv.matches(newDigestMatcher(w.targets))
+	var events []matchEvent
+	for _, target := range w.targets {
+		previous := w.matches[target]
+		for _, tag := range previous {
+			if unchecked[tag] && !slices.Contains(current[target], tag) {
+				// Unknown this time round, so assume it has not moved
+				current[target] = append(current[target], tag)
+				continue
+			}
+			if !slices.Contains(current[target], tag) {
+				events = append(events, matchEvent{Time: now, Image: w.image, Target: target, Tag: tag, Digest: inv.digests[tag], Change: changeRemoved})
+			}
+		}
+		for _, tag := range current[target] {
+			if !slices.Contains(previous, tag) {
+				events = append(events, matchEvent{Time: now, Image: w.image, Target: target, Tag: tag, Digest: inv.digests[tag], Change: changeAdded})
+			}
+		}
+		slices.Sort(current[target])
+	}
+
+	w.matches = current
+	return events, inv, nil
+}
+
+// runWatch runs the watch subcommand: it scans an image on an interval and
+// reports changes in the tags matching the target digests
+func runWatch(args []string, opts clientOptions, minPrefix int) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", 5*time.Minute, "time to wait between scans")
+	format := fs.String("format", "text", "write events to stdout as `format` text or ndjson, or none")
+	scans := fs.Int("scans", 0, "stop after this many scans (default run until interrupted)")
+	if err := fs.Parse(args); err != nil {
+		return exitFatal
+	}
+	if fs.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: tag-finder [flags] watch [-interval duration] [-format text|ndjson|none] <image> <digest> [digest...]")
+		return exitFatal
+	}
+	if *interval <= 0 || *scans < 0 {
+		fmt.Fprintln(os.Stderr, "Error: interval must be positive and scans must not be negative")
+		return exitFatal
+	}
+
+	image := strings.TrimPrefix(fs.Arg(0), "docker://")
+	var targets []string
+	for _, arg := range fs.Args()[1:] {
+		digest, err := parseDigest(arg, minPrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFatal
+		}
+		targets = append(targets, digest)
+	}
+
+	var notifiers []notifier
+	switch *format {
+	case "text", "ndjson":
+		notifiers = append(notifiers, &writerNotifier{w: os.Stdout, ndjson: *format == "ndjson", showTarget: len(targets) > 1})
+	case "none":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (want text, ndjson or none)\n", *format)
+		return exitFatal
+	}
+	notifiers = append(notifiers, opts.notifiers...)
+
+	opts.sha512 = needsSHA512(targets)
+
+	// Every scan after the first revalidates cached manifests, so tags that
+	// have not moved cost a 304 instead of a full response
+	opts.cache = registry.NewCache(0)
+	w := newWatcher(opts.newClient(), image, targets)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	setupSignalHandler(cancel)
+
+	events := newDispatcher(ctx, notifiers)
+	defer events.close()
+
+	for i := 0; *scans == 0 || i < *scans; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return exitMatch
+			case <-time.After(*interval):
+			}
+		}
+
+		scanCtx, cancelScan := ctx, context.CancelFunc(func() {})
+		if opts.scanTimeout > 0 {
+			scanCtx, cancelScan = context.WithTimeout(ctx, opts.scanTimeout)
+		}
+		changes, inv, err := w.scan(scanCtx)
+		cancelScan()
+		if err := opts.history.flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: saving history: %v\n", err)
+		}
+		if ctx.Err() != nil {
+			return exitMatch
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: scan failed, keeping the previous state: %v\n", err)
+			continue
+		}
+		if len(inv.failed) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %d of %d tags could not be checked and keep their previous state\n", len(inv.failed), inv.total)
+		}
+
+		for _, event := range changes {
+			events.send(event)
+		}
+	}
+	return exitMatch
+}
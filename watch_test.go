@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"oci-tag-finder/internal/registrytest"
+)
+
+// eventSummary reduces events to "change tag" strings for comparison
+func eventSummary(events []matchEvent) []string {
+	var summary []string
+	for _, e := range events {
+		summary = append(summary, e.Change+" "+e.Tag)
+	}
+	return summary
+}
+
+// Test the watcher reports tags starting and stopping to match, and only
+// changes since the previous scan
+func TestWatcher_Scan(t *testing.T) {
+	fake := registrytest.New(registrytest.WithPageSize(2))
+	defer fake.Close()
+	v1 := fake.PushImage("app", "v1")
+	fake.Tag("app", "latest", v1)
+	v2 := fake.PushImage("app", "v2")
+
+	opts := fakeRegistryOptions(fake, "", "")
+	w := newWatcher(opts.newClient(), fake.Ref("app"), []string{v1})
+	ctx := context.Background()
+
+	steps := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{"first scan", func() {}, []string{"added latest", "added v1"}},
+		{"no change", func() {}, nil},
+		{"tag moved away", func() { fake.Tag("app", "latest", v2) }, []string{"removed latest"}},
+		{"tag added", func() { fake.Tag("app", "stable", v1) }, []string{"added stable"}},
+		{"tag deleted", func() { fake.Untag("app", "v1") }, []string{"removed v1"}},
+	}
+	for _, step := range steps {
+		step.change()
+		events, _, err := w.scan(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := eventSummary(events); !slices.Equal(got, step.want) {
+			t.Errorf("%s: events = %v, want %v", step.name, got, step.want)
+		}
+		for _, e := range events {
+			if e.Target != v1 || e.Image != fake.Ref("app") {
+				t.Errorf("%s: event = %+v", step.name, e)
+			}
+		}
+	}
+
+	// A tag that cannot be checked keeps its previous state
+	fake.Inject(registrytest.Fault{Path: "/manifests/stable", Status: http.StatusInternalServerError})
+	events, inv, err := w.scan(ctx)
+	if err != nil || len(events) != 0 || len(inv.failed) != 1 {
+		t.Errorf("failed tag: events = %v, failed = %v, err = %v", eventSummary(events), inv.failed, err)
+	}
+	if got := w.matches[v1]; !slices.Equal(got, []string{"stable"}) {
+		t.Errorf("matches after failed tag = %v, want [stable]", got)
+	}
+
+	// A failed listing changes nothing
+	fake.Inject(registrytest.Fault{Path: "/tags/", Status: http.StatusNotFound})
+	if events, _, err := w.scan(ctx); err == nil || len(events) != 0 {
+		t.Errorf("failed listing: events = %v, err = %v", eventSummary(events), err)
+	}
+	if got := w.matches[v1]; !slices.Equal(got, []string{"stable"}) {
+		t.Errorf("matches after failed listing = %v, want [stable]", got)
+	}
+}